@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"proxy/proxy"
+)
+
+// runCheck chạy lệnh check hoặc import: phân tích các danh sách proxy, báo dòng lỗi, proxy trùng và sai loại,
+// kiểm tra từng proxy rồi ghi danh sách proxy hoạt động (bắt buộc với import). Trả về mã thoát.
+func runCheck(command string, args []string) int {
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	listType := flags.String("type", "http", "Loại proxy cho dòng không có scheme, khai báo thì báo cả dòng có scheme khác loại")
+	format := flags.String("format", "", "Định dạng danh sách: text, json, yaml, csv (mặc định theo phần mở rộng)")
+	target := flags.String("target", "http://httpbin.org/get", "URL kiểm tra, judge kiểu httpbin.org/get cho biết thêm IP đầu ra")
+	timeout := flags.Duration("timeout", 10*time.Second, "Thời gian chờ tối đa cho mỗi proxy")
+	concurrency := flags.Int("concurrency", 20, "Số proxy kiểm tra đồng thời")
+	output := flags.String("o", "", "File CSV ghi các proxy hoạt động kèm độ trễ và IP đầu ra")
+	noTest := flags.Bool("no-test", false, "Chỉ kiểm tra định dạng, không kết nối tới proxy")
+	configFile := flags.String("config", "", "File cấu hình để nạp file bí mật cho các tham chiếu ${secrets.NAME}")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s [flags] file...\n", os.Args[0], command)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 || (command == "import" && *output == "") {
+		flags.Usage()
+		return 2
+	}
+
+	if *configFile != "" {
+		cfg, err := proxy.LoadConfig(*configFile)
+		if err == nil {
+			err = proxy.ConfigureSecrets(cfg.Secrets)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	proxyType, err := proxy.ParseProxyType(*listType)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	validator := &proxy.ListValidator{Type: proxyType}
+	flags.Visit(func(f *flag.Flag) {
+		validator.StrictType = validator.StrictType || f.Name == "type"
+	})
+
+	var entries []*proxy.ListEntry
+	problems := 0
+	report := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, filename := range flags.Args() {
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+
+		listFormat := *format
+		if listFormat == "" {
+			listFormat, _ = proxy.InventoryFormat(filename)
+		}
+		fileEntries, issues, err := validator.Validate(filename, data, listFormat)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", filename, err)
+			return 1
+		}
+		for _, issue := range issues {
+			fmt.Fprintf(report, "%s\t%s:%d\t%s\n", issue.Kind, issue.Source, issue.Line, issue.Message)
+		}
+		problems += len(issues)
+		entries = append(entries, fileEntries...)
+	}
+	report.Flush()
+
+	if *noTest {
+		fmt.Printf("%d proxies, %d problems\n", len(entries), problems)
+		return exitStatus(problems)
+	}
+
+	results := proxy.CheckProxies(entries, proxy.CheckOptions{Target: *target, Timeout: *timeout, Concurrency: *concurrency})
+	working, mismatched := 0, 0
+	for _, result := range results {
+		p := result.Entry.Proxy
+		switch {
+		case result.Mismatch():
+			mismatched++
+			fmt.Fprintf(report, "MISMATCH\t%s\t%s\t%dms\t%s\tlisted as %s, works as %s\n",
+				p.URL, result.DetectedType, result.Latency.Milliseconds(), result.ExitIP, p.Type, result.DetectedType)
+		case result.Working():
+			fmt.Fprintf(report, "OK\t%s\t%s\t%dms\t%s\t\n", p.URL, p.Type, result.Latency.Milliseconds(), result.ExitIP)
+		default:
+			fmt.Fprintf(report, "FAIL\t%s\t%s\t-\t-\t%v\n", p.URL, p.Type, result.Err)
+			continue
+		}
+		working++
+	}
+	report.Flush()
+	problems += len(results) - working + mismatched
+	fmt.Printf("%d proxies, %d working, %d failed, %d type mismatches, %d problems\n",
+		len(results), working, len(results)-working, mismatched, problems)
+
+	if *output != "" {
+		written := 0
+		file, err := os.Create(*output)
+		if err == nil {
+			written, err = proxy.WriteCheckedList(file, results)
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *output, err)
+			return 1
+		}
+		fmt.Printf("Wrote %d working proxies to %s\n", written, *output)
+	}
+	return exitStatus(problems)
+}
+
+// exitStatus trả về 1 khi danh sách có vấn đề để dùng được trong script trước khi triển khai
+func exitStatus(problems int) int {
+	if problems > 0 {
+		return 1
+	}
+	return 0
+}
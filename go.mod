@@ -3,7 +3,15 @@ module proxy
 go 1.24.1
 
 require (
-	github.com/elazarl/goproxy v1.7.2 // indirect
-	golang.org/x/net v0.35.0 // indirect
+	github.com/elazarl/goproxy v1.7.2
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/oschwald/maxminddb-golang v1.13.1
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/net v0.35.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	golang.org/x/sys v0.30.0 // indirect
 	golang.org/x/text v0.22.0 // indirect
 )
@@ -1,48 +1,202 @@
 package main
 
 import (
+	"flag"
 	"log"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"proxy/proxy"
+	"proxy/utils"
 )
 
-const (
-	// Cấu hình file proxy
-	httpProxyFile   = "proxy_http.txt"
-	socks5ProxyFile = "proxy_sockets5.txt"
+func main() {
+	// Che mật khẩu upstream và header xác thực trong mọi dòng log
+	log.SetOutput(utils.NewRedactWriter(os.Stderr))
 
-	// Cổng lắng nghe
-	serverPort = ":8081"
-)
+	// Lệnh con kiểm tra danh sách proxy và quản lý file bí mật, không khởi động server
+	if len(os.Args) > 1 && (os.Args[1] == "check" || os.Args[1] == "import") {
+		os.Exit(runCheck(os.Args[1], os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "secrets" {
+		os.Exit(runSecrets(os.Args[2:]))
+	}
+
+	configFile := flag.String("config", "config.json", "Đường dẫn file cấu hình")
+	flag.Parse()
 
-func main() {
 	log.Println("[INFO] Khởi động proxy server")
 
+	// Tải cấu hình, dùng mặc định nếu chưa có file
+	cfg := proxy.DefaultConfig()
+	if _, err := os.Stat(*configFile); err == nil {
+		if cfg, err = proxy.LoadConfig(*configFile); err != nil {
+			log.Fatalf("[ERROR] Failed to load config: %v", err)
+		}
+		log.Printf("[INFO] Loaded config from %s", *configFile)
+	}
+
 	// Tạo proxy manager
 	pm := proxy.NewProxyManager()
 
-	// Tải proxy từ nhiều file
-	if err := proxy.LoadProxiesFromMultipleFiles(httpProxyFile, socks5ProxyFile, pm); err != nil {
-		log.Fatalf("[ERROR] Failed to load proxies: %v", err)
+	if err := proxy.ApplyConfig(pm, cfg); err != nil {
+		log.Fatalf("[ERROR] Failed to apply config: %v", err)
+	}
+
+	// Tải proxy từ inventory và các file danh sách
+	proxy.LoadInventoryFiles(cfg.InventoryFiles, pm)
+	if err := proxy.LoadProxiesFromMultipleFiles(cfg.HTTPProxyFile, cfg.SOCKS5ProxyFile, pm); err != nil {
+		if len(cfg.Subscriptions) == 0 && len(cfg.Providers) == 0 && len(cfg.GatewayUpstreams) == 0 {
+			log.Fatalf("[ERROR] Failed to load proxies: %v", err)
+		}
+		log.Printf("[WARN] %v, relying on subscriptions, providers and gateway upstreams", err)
+	}
+
+	// Theo dõi file proxy để tải lại khi thay đổi
+	var watchers []*proxy.ProxyListWatcher
+	watcher, err := proxy.WatchProxyLists(cfg.HTTPProxyFile, cfg.SOCKS5ProxyFile, cfg.InventoryFiles, pm)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to watch proxy files: %v", err)
+	}
+	watchers = append(watchers, watcher)
+
+	// Tải các pool có tên và bảng định tuyến
+	pools, err := proxy.LoadPools(pm, cfg)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to load pools: %v", err)
+	}
+	if err := proxy.ConfigureRouting(cfg.Routing, pools); err != nil {
+		log.Fatalf("[ERROR] Failed to configure routing: %v", err)
+	}
+
+	// Các pool có danh sách proxy riêng, theo tên dùng khi lưu trạng thái
+	ownedPools := map[string]*proxy.ProxyManager{proxy.DefaultPoolName: pm}
+	for _, pc := range cfg.Pools {
+		if pc.HasProxyFiles() {
+			ownedPools[pc.Name] = pools[pc.Name]
+			watcher, err := proxy.WatchProxyLists(pc.HTTPProxyFile, pc.SOCKS5ProxyFile, pc.InventoryFiles, pools[pc.Name])
+			if err != nil {
+				log.Fatalf("[ERROR] Failed to watch proxy files of pool %s: %v", pc.Name, err)
+			}
+			watchers = append(watchers, watcher)
+		}
+	}
+
+	// Tải các danh sách proxy từ xa
+	subscriptions, err := proxy.StartSubscriptions(cfg.Subscriptions, ownedPools)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to start subscriptions: %v", err)
+	}
+
+	// Duy trì proxy từ các nhà cung cấp
+	providers, err := proxy.StartProviders(cfg.Providers, ownedPools)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to start providers: %v", err)
+	}
+
+	// Khôi phục trạng thái đã lưu trước khi health check chạy
+	var statePersister *proxy.StatePersister
+	if cfg.State != nil {
+		if statePersister, err = proxy.StartStatePersistence(cfg.State, ownedPools); err != nil {
+			log.Fatalf("[ERROR] Failed to start state persistence: %v", err)
+		}
 	}
 
-	// Bắt đầu giám sát danh sách proxy
-	go proxy.MonitorProxyList(httpProxyFile, socks5ProxyFile, pm)
+	// Tạo session cho các gateway upstream, dùng store để giữ session sticky qua các lần khởi động
+	var store proxy.Store
+	if statePersister != nil {
+		store = statePersister.Store()
+	}
+	gateways, err := proxy.StartGatewayUpstreams(cfg.GatewayUpstreams, ownedPools, store)
+	if err != nil {
+		log.Fatalf("[ERROR] Failed to start gateway upstreams: %v", err)
+	}
 
-	// Khởi động proxy server
-	go func() {
-		if err := proxy.StartProxyServer(pm, serverPort); err != nil {
-			log.Fatalf("[ERROR] Failed to start proxy server: %v", err)
+	// Kiểm tra sức khỏe proxy định kỳ
+	var healthCheckers []*proxy.HealthChecker
+	for name, pool := range ownedPools {
+		hc, err := proxy.StartHealthChecker(pool, cfg.HealthCheck)
+		if err != nil {
+			log.Fatalf("[ERROR] Failed to start health checker for pool %s: %v", name, err)
 		}
-	}()
+		healthCheckers = append(healthCheckers, hc)
+	}
 
-	// Xử lý tắt graceful
+	// Khởi động các listener
+	for _, lc := range cfg.Listeners {
+		go func(lc proxy.ListenerConfig) {
+			if err := proxy.StartListener(pm, lc); err != nil {
+				log.Fatalf("[ERROR] Failed to start proxy server: %v", err)
+			}
+		}(lc)
+	}
+
+	// Khởi động API quản trị
+	if cfg.API != nil {
+		go func() {
+			if err := proxy.StartAPIServer(ownedPools, cfg.API); err != nil {
+				log.Fatalf("[ERROR] Failed to start API server: %v", err)
+			}
+		}()
+	}
+
+	// SIGHUP tải lại cấu hình và danh sách proxy, SIGINT/SIGTERM tắt graceful
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-	<-sigChan
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reload(*configFile, pm, pools, watchers, subscriptions, providers)
+	}
 
 	log.Println("[INFO] Shutting down server...")
+	for _, w := range watchers {
+		w.Stop()
+	}
+	for _, s := range subscriptions {
+		s.Stop()
+	}
+	for _, p := range providers {
+		p.Stop()
+	}
+	for _, g := range gateways {
+		g.Stop()
+	}
+	for _, hc := range healthCheckers {
+		if hc != nil {
+			hc.Stop()
+		}
+	}
+	if statePersister != nil {
+		statePersister.Stop()
+	}
+}
+
+// reload đọc lại file cấu hình, các file proxy, subscription và nhà cung cấp khi nhận SIGHUP, giữ cấu hình cũ nếu file lỗi
+func reload(configFile string, pm *proxy.ProxyManager, pools map[string]*proxy.ProxyManager,
+	watchers []*proxy.ProxyListWatcher, subscriptions []*proxy.Subscription, providers []*proxy.ProviderSync) {
+	log.Println("[INFO] Received SIGHUP, reloading config and proxy lists")
+
+	if _, err := os.Stat(configFile); err == nil {
+		cfg, err := proxy.LoadConfig(configFile)
+		if err != nil {
+			log.Printf("[ERROR] Failed to reload config, keeping current settings: %v", err)
+		} else if err := proxy.ReloadConfig(pm, pools, cfg); err != nil {
+			log.Printf("[ERROR] Failed to apply reloaded config: %v", err)
+		} else {
+			log.Printf("[INFO] Reloaded config from %s", configFile)
+		}
+	}
+
+	for _, w := range watchers {
+		w.Reload()
+	}
+	for _, s := range subscriptions {
+		s.Refresh()
+	}
+	for _, p := range providers {
+		p.Refresh()
+	}
 }
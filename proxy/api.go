@@ -0,0 +1,257 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// APIConfig cấu hình API quản trị
+type APIConfig struct {
+	Addr string `json:"addr"`
+
+	// Token nếu khác rỗng, mọi request phải gửi header "Authorization: Bearer <token>"
+	Token string `json:"token,omitempty"`
+}
+
+// apiServer phục vụ API quản trị của proxy server
+type apiServer struct {
+	pools map[string]*ProxyManager
+	cfg   *APIConfig
+	mux   *http.ServeMux
+}
+
+// StartAPIServer khởi động API quản trị cho các pool có danh sách proxy riêng theo tên,
+// gồm cả pool mặc định DefaultPoolName
+func StartAPIServer(pools map[string]*ProxyManager, cfg *APIConfig) error {
+	token, err := ResolveSecrets(cfg.Token)
+	if err != nil {
+		return fmt.Errorf("api token: %v", err)
+	}
+	cfg = &APIConfig{Addr: cfg.Addr, Token: token}
+
+	s := &apiServer{
+		pools: pools,
+		cfg:   cfg,
+		mux:   http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /api/proxies", s.handleProxies)
+	s.mux.HandleFunc("POST /api/proxies/retire", s.handleRetireProxy)
+	s.mux.HandleFunc("POST /api/proxies/revive", s.handleReviveProxy)
+	s.mux.HandleFunc("GET /api/reloads", s.handleReloads)
+	s.mux.HandleFunc("GET /api/bans", s.handleBans)
+	s.mux.HandleFunc("POST /api/bans", s.handleBan)
+	s.mux.HandleFunc("POST /api/bans/lift", s.handleUnban)
+	s.mux.HandleFunc("GET /api/cache/stats", s.handleCacheStats)
+	s.mux.HandleFunc("POST /api/cache/purge", s.handleCachePurge)
+
+	logger.Info("Starting API server on %s", cfg.Addr)
+	return http.ListenAndServe(cfg.Addr, s)
+}
+
+func (s *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.cfg.Token != "" {
+		expected := "Bearer " + s.cfg.Token
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+			writeAPIError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+	}
+
+	s.mux.ServeHTTP(w, r)
+}
+
+// writeAPIData trả về JSON dạng {"status": "success", "data": ...}
+func writeAPIData(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   data,
+	})
+}
+
+// writeAPIError trả về JSON dạng {"status": "error", "message": ...}
+func writeAPIError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "error",
+		"message": message,
+	})
+}
+
+// pool trả về pool theo tham số "pool" của query, mặc định là pool mặc định
+func (s *apiServer) pool(w http.ResponseWriter, r *http.Request) (*ProxyManager, bool) {
+	name := r.URL.Query().Get("pool")
+	if name == "" {
+		name = DefaultPoolName
+	}
+	pm, ok := s.pools[name]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, fmt.Sprintf("unknown pool %q or pool has no proxy files", name))
+		return nil, false
+	}
+	return pm, true
+}
+
+// handleProxies trả về trạng thái và thống kê hiệu năng của từng proxy
+func (s *apiServer) handleProxies(w http.ResponseWriter, r *http.Request) {
+	pm, ok := s.pool(w, r)
+	if !ok {
+		return
+	}
+	writeAPIData(w, pm.ProxyInfos())
+}
+
+// handleReloads trả về các lần tải lại danh sách proxy gần nhất
+func (s *apiServer) handleReloads(w http.ResponseWriter, r *http.Request) {
+	pm, ok := s.pool(w, r)
+	if !ok {
+		return
+	}
+	writeAPIData(w, pm.Reloads())
+}
+
+// readProxyURL đọc body JSON {"url": ...} của các API thao tác trên một proxy
+func readProxyURL(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		writeAPIError(w, http.StatusBadRequest, "body must be JSON with a non-empty \"url\"")
+		return "", false
+	}
+	return req.URL, true
+}
+
+// handleRetireProxy loại proxy khỏi vòng chọn vĩnh viễn
+func (s *apiServer) handleRetireProxy(w http.ResponseWriter, r *http.Request) {
+	pm, ok := s.pool(w, r)
+	if !ok {
+		return
+	}
+	proxyURL, ok := readProxyURL(w, r)
+	if !ok {
+		return
+	}
+	if !pm.RetireProxy(proxyURL) {
+		writeAPIError(w, http.StatusNotFound, "proxy not found")
+		return
+	}
+	writeAPIData(w, map[string]string{"url": proxyURL, "state": BreakerRetired.String()})
+}
+
+// handleReviveProxy đưa proxy về trạng thái closed
+func (s *apiServer) handleReviveProxy(w http.ResponseWriter, r *http.Request) {
+	pm, ok := s.pool(w, r)
+	if !ok {
+		return
+	}
+	proxyURL, ok := readProxyURL(w, r)
+	if !ok {
+		return
+	}
+	if !pm.ReviveProxy(proxyURL) {
+		writeAPIError(w, http.StatusNotFound, "proxy not found")
+		return
+	}
+	writeAPIData(w, map[string]string{"url": proxyURL, "state": BreakerClosed.String()})
+}
+
+// handleBans trả về các proxy đang bị ban theo tên miền đích
+func (s *apiServer) handleBans(w http.ResponseWriter, r *http.Request) {
+	pm, ok := s.pool(w, r)
+	if !ok {
+		return
+	}
+	writeAPIData(w, pm.Bans())
+}
+
+// banRequest là body của các API ban/gỡ ban
+type banRequest struct {
+	URL      string `json:"url"`
+	Domain   string `json:"domain"`
+	Duration string `json:"duration"`
+}
+
+// handleBan ban proxy với một tên miền, "duration" để trống thì dùng thời gian đã cấu hình
+func (s *apiServer) handleBan(w http.ResponseWriter, r *http.Request) {
+	pm, ok := s.pool(w, r)
+	if !ok {
+		return
+	}
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" || req.Domain == "" {
+		writeAPIError(w, http.StatusBadRequest, "body must be JSON with non-empty \"url\" and \"domain\"")
+		return
+	}
+
+	var duration time.Duration
+	if req.Duration != "" {
+		var err error
+		if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid duration")
+			return
+		}
+	}
+
+	until, ok := pm.BanProxy(req.URL, req.Domain, duration)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "proxy not found")
+		return
+	}
+	writeAPIData(w, BanInfo{URL: req.URL, Domain: normalizeDomain(req.Domain), Until: until})
+}
+
+// handleUnban gỡ ban của proxy với "domain", để trống để gỡ mọi ban của proxy
+func (s *apiServer) handleUnban(w http.ResponseWriter, r *http.Request) {
+	pm, ok := s.pool(w, r)
+	if !ok {
+		return
+	}
+	var req banRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.URL == "" {
+		writeAPIError(w, http.StatusBadRequest, "body must be JSON with a non-empty \"url\"")
+		return
+	}
+	if !pm.UnbanProxy(req.URL, req.Domain) {
+		writeAPIError(w, http.StatusNotFound, "proxy not found")
+		return
+	}
+	writeAPIData(w, map[string]string{"url": req.URL, "domain": normalizeDomain(req.Domain)})
+}
+
+func (s *apiServer) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	c := responseCache.Load()
+	if c == nil {
+		writeAPIError(w, http.StatusNotFound, "cache is not enabled")
+		return
+	}
+	writeAPIData(w, c.Stats())
+}
+
+// handleCachePurge xóa cache theo "url", "host" hoặc toàn bộ nếu không truyền gì
+func (s *apiServer) handleCachePurge(w http.ResponseWriter, r *http.Request) {
+	c := responseCache.Load()
+	if c == nil {
+		writeAPIError(w, http.StatusNotFound, "cache is not enabled")
+		return
+	}
+
+	var req struct {
+		URL  string `json:"url"`
+		Host string `json:"host"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid JSON body")
+			return
+		}
+	}
+
+	removed := c.purge(req.Host, req.URL)
+	writeAPIData(w, map[string]int{"removed": removed})
+}
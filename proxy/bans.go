@@ -0,0 +1,192 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultBanDuration thời gian ban mặc định cho proxy bị một đích chặn
+const defaultBanDuration = 30 * time.Minute
+
+// BanConfig bật theo dõi proxy bị chặn theo từng tên miền đích. Khi bật, phản hồi có mã trong
+// retry_status_codes ban proxy với tên miền đó thay vì tính là lỗi của proxy.
+type BanConfig struct {
+	// Duration thời gian ban, mặc định 30m
+	Duration string `json:"duration,omitempty"`
+}
+
+// BanInfo mô tả một proxy đang bị ban với một tên miền
+type BanInfo struct {
+	URL    string    `json:"url"`
+	Domain string    `json:"domain"`
+	Until  time.Time `json:"until"`
+}
+
+// SetBans cấu hình ban theo đích cho các phản hồi bị thử lại, nil để tắt
+func (pm *ProxyManager) SetBans(cfg *BanConfig) error {
+	duration, err := parseBanDuration(cfg)
+	if err != nil {
+		return err
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.banDuration = duration
+	return nil
+}
+
+// parseBanDuration trả về thời gian ban theo cấu hình, 0 khi tắt ban
+func parseBanDuration(cfg *BanConfig) (time.Duration, error) {
+	if cfg == nil {
+		return 0, nil
+	}
+	if cfg.Duration == "" {
+		return defaultBanDuration, nil
+	}
+	parsed, err := time.ParseDuration(cfg.Duration)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("invalid ban duration %q", cfg.Duration)
+	}
+	return parsed, nil
+}
+
+// ForDestination trả về ProxyManager dùng chung pool nhưng bỏ qua các proxy đang bị ban với host
+func (pm *ProxyManager) ForDestination(host string) *ProxyManager {
+	domain := normalizeDomain(host)
+	if domain == "" {
+		return pm
+	}
+	view := *pm
+	view.destination = domain
+	return &view
+}
+
+// normalizeDomain bỏ port, dấu chấm cuối và đưa tên miền về chữ thường
+func normalizeDomain(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(host, "[]")), ".")
+}
+
+// bannedFor kiểm tra proxy có đang bị ban với domain hoặc một tên miền cha của nó. Gọi khi đang giữ pm.mu.
+func (p *Proxy) bannedFor(domain string, now time.Time) bool {
+	if domain == "" || len(p.bans) == 0 {
+		return false
+	}
+
+	for {
+		if until, ok := p.bans[domain]; ok && now.Before(until) {
+			return true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 || net.ParseIP(domain) != nil {
+			return false
+		}
+		domain = domain[i+1:]
+	}
+}
+
+// banProxy ban proxy với domain trong khoảng duration. Gọi khi đang giữ pm.mu (khóa ghi).
+func (pm *ProxyManager) banProxy(proxy *Proxy, domain string, duration time.Duration) time.Time {
+	now := time.Now()
+	if proxy.bans == nil {
+		proxy.bans = make(map[string]time.Time)
+	}
+	for d, until := range proxy.bans {
+		if !now.Before(until) {
+			delete(proxy.bans, d)
+		}
+	}
+
+	until := now.Add(duration)
+	proxy.bans[domain] = until
+	logger.Warn("Proxy %s banned for %s until %s", proxy.URL, domain, until.Format(time.RFC3339))
+	return until
+}
+
+// banForStatus ghi nhận proxy bị host chặn khi ban theo đích được bật, trả về false nếu đang tắt
+func (pm *ProxyManager) banForStatus(proxy *Proxy, host string) bool {
+	domain := normalizeDomain(host)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if pm.banDuration <= 0 || domain == "" {
+		return false
+	}
+	pm.banProxy(proxy, domain, pm.banDuration)
+	return true
+}
+
+// BanProxy ban proxy với tên miền (áp dụng cả cho tên miền con) trong duration,
+// duration <= 0 dùng thời gian đã cấu hình. Trả về false nếu không tìm thấy proxy.
+func (pm *ProxyManager) BanProxy(proxyURL, domain string, duration time.Duration) (time.Time, bool) {
+	domain = normalizeDomain(domain)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if duration <= 0 {
+		duration = pm.banDuration
+	}
+	if duration <= 0 {
+		duration = defaultBanDuration
+	}
+
+	for _, proxy := range pm.proxies {
+		if proxy.URL == proxyURL {
+			return pm.banProxy(proxy, domain, duration), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// UnbanProxy gỡ ban của proxy với tên miền, domain rỗng để gỡ mọi ban. Trả về false nếu không tìm thấy proxy.
+func (pm *ProxyManager) UnbanProxy(proxyURL, domain string) bool {
+	domain = normalizeDomain(domain)
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, proxy := range pm.proxies {
+		if proxy.URL != proxyURL {
+			continue
+		}
+		if domain == "" {
+			proxy.bans = nil
+		} else {
+			delete(proxy.bans, domain)
+		}
+		logger.Info("Proxy %s unbanned for %q", proxy.URL, domain)
+		return true
+	}
+	return false
+}
+
+// Bans trả về các ban còn hiệu lực, sắp xếp theo proxy rồi tên miền
+func (pm *ProxyManager) Bans() []BanInfo {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	now := time.Now()
+	bans := []BanInfo{}
+	for _, proxy := range pm.proxies {
+		for domain, until := range proxy.bans {
+			if now.Before(until) {
+				bans = append(bans, BanInfo{URL: proxy.URL, Domain: domain, Until: until})
+			}
+		}
+	}
+
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].URL != bans[j].URL {
+			return bans[i].URL < bans[j].URL
+		}
+		return bans[i].Domain < bans[j].Domain
+	})
+	return bans
+}
@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+)
+
+// BreakerState là trạng thái circuit breaker của một proxy
+type BreakerState int
+
+const (
+	// BreakerClosed proxy hoạt động bình thường và được chọn
+	BreakerClosed BreakerState = iota
+	// BreakerOpen proxy tạm ngưng cho tới khi hết thời gian backoff
+	BreakerOpen
+	// BreakerHalfOpen hết backoff, cho phép một request thử để quyết định đóng lại hay mở tiếp
+	BreakerHalfOpen
+	// BreakerRetired proxy bị loại khỏi vòng chọn vĩnh viễn, không tự phục hồi
+	BreakerRetired
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	case BreakerRetired:
+		return "retired"
+	}
+	return fmt.Sprintf("BreakerState(%d)", int(s))
+}
+
+// MarshalText cho phép API trả trạng thái dạng chuỗi
+func (s BreakerState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// CircuitBreakerConfig cấu hình circuit breaker cho các proxy trong pool
+type CircuitBreakerConfig struct {
+	// FailureThreshold số lần lỗi liên tiếp để mở breaker
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+
+	// OpenDuration thời gian mở lần đầu, nhân đôi sau mỗi lần probe thất bại tới MaxOpenDuration
+	OpenDuration    string `json:"open_duration,omitempty"`
+	MaxOpenDuration string `json:"max_open_duration,omitempty"`
+
+	// RetireAfter số lần mở liên tiếp trước khi loại proxy vĩnh viễn, 0 để không bao giờ loại
+	RetireAfter int `json:"retire_after,omitempty"`
+}
+
+// breakerSettings là cấu hình circuit breaker đã được parse
+type breakerSettings struct {
+	openDuration    time.Duration
+	maxOpenDuration time.Duration
+	retireAfter     int
+}
+
+// probeTimeout thời gian tối đa chờ kết quả request thử ở trạng thái half-open
+// trước khi cho phép một request thử khác
+const probeTimeout = 30 * time.Second
+
+func defaultBreakerSettings() breakerSettings {
+	return breakerSettings{
+		openDuration:    30 * time.Second,
+		maxOpenDuration: 30 * time.Minute,
+	}
+}
+
+// SetCircuitBreaker áp dụng cấu hình circuit breaker, nil để dùng mặc định
+func (pm *ProxyManager) SetCircuitBreaker(cfg *CircuitBreakerConfig) error {
+	failureThreshold, settings, err := parseCircuitBreaker(cfg)
+	if err != nil {
+		return err
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.maxFails = failureThreshold
+	pm.breaker = settings
+	return nil
+}
+
+// parseCircuitBreaker kiểm tra cấu hình circuit breaker, trả về ngưỡng lỗi và thiết lập breaker
+func parseCircuitBreaker(cfg *CircuitBreakerConfig) (int, breakerSettings, error) {
+	settings := defaultBreakerSettings()
+	failureThreshold := 5
+
+	if cfg != nil {
+		if cfg.FailureThreshold > 0 {
+			failureThreshold = cfg.FailureThreshold
+		}
+		if cfg.OpenDuration != "" {
+			d, err := time.ParseDuration(cfg.OpenDuration)
+			if err != nil {
+				return 0, settings, fmt.Errorf("invalid circuit breaker open_duration: %v", err)
+			}
+			settings.openDuration = d
+		}
+		if cfg.MaxOpenDuration != "" {
+			d, err := time.ParseDuration(cfg.MaxOpenDuration)
+			if err != nil {
+				return 0, settings, fmt.Errorf("invalid circuit breaker max_open_duration: %v", err)
+			}
+			settings.maxOpenDuration = d
+		}
+		settings.retireAfter = cfg.RetireAfter
+	}
+	return failureThreshold, settings, nil
+}
+
+// isSelectable kiểm tra proxy có được đưa vào vòng chọn hay không, chuyển proxy
+// đã hết backoff sang half-open. Gọi khi đang giữ pm.mu (khóa ghi).
+func (pm *ProxyManager) isSelectable(proxy *Proxy, now time.Time) bool {
+	switch proxy.State {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if now.Before(proxy.OpenUntil) {
+			return false
+		}
+		proxy.State = BreakerHalfOpen
+		proxy.probeStart = time.Time{}
+		logger.Info("Circuit half-open for proxy %s", proxy.URL)
+		return true
+	case BreakerHalfOpen:
+		// Chỉ một request thử tại một thời điểm
+		return proxy.probeStart.IsZero() || now.Sub(proxy.probeStart) > probeTimeout
+	}
+	return false
+}
+
+// onSelected đánh dấu request thử khi proxy half-open được chọn. Gọi khi đang giữ pm.mu.
+func (pm *ProxyManager) onSelected(proxy *Proxy, now time.Time) {
+	if proxy.State == BreakerHalfOpen {
+		proxy.probeStart = now
+	}
+}
+
+// tripBreaker mở breaker với backoff tăng theo cấp số nhân. Gọi khi đang giữ pm.mu.
+func (pm *ProxyManager) tripBreaker(proxy *Proxy) {
+	proxy.trips++
+
+	if pm.breaker.retireAfter > 0 && proxy.trips >= pm.breaker.retireAfter {
+		proxy.State = BreakerRetired
+		logger.Warn("Retiring proxy %s after %d consecutive circuit trips", proxy.URL, proxy.trips)
+		return
+	}
+
+	backoff := pm.breaker.openDuration
+	for i := 1; i < proxy.trips && backoff < pm.breaker.maxOpenDuration; i++ {
+		backoff *= 2
+	}
+	if backoff > pm.breaker.maxOpenDuration {
+		backoff = pm.breaker.maxOpenDuration
+	}
+
+	proxy.State = BreakerOpen
+	proxy.OpenUntil = time.Now().Add(backoff)
+	logger.Info("Circuit open for proxy %s for %v (trip %d)", proxy.URL, backoff, proxy.trips)
+}
+
+// recordFailure cập nhật breaker khi proxy lỗi. Gọi khi đang giữ pm.mu.
+func (pm *ProxyManager) recordFailure(proxy *Proxy) {
+	proxy.FailCount++
+
+	switch proxy.State {
+	case BreakerHalfOpen:
+		pm.tripBreaker(proxy)
+	case BreakerClosed:
+		if proxy.FailCount >= pm.maxFails {
+			pm.tripBreaker(proxy)
+		}
+	}
+}
+
+// recordSuccess đóng breaker khi proxy thành công. Gọi khi đang giữ pm.mu.
+func (pm *ProxyManager) recordSuccess(proxy *Proxy) {
+	proxy.FailCount = 0
+	if proxy.State == BreakerOpen || proxy.State == BreakerHalfOpen {
+		logger.Info("Circuit closed for proxy %s", proxy.URL)
+		proxy.State = BreakerClosed
+		proxy.trips = 0
+	}
+}
+
+// MarkProxyFailed ghi nhận lỗi của proxy, mở breaker khi vượt ngưỡng
+func (pm *ProxyManager) MarkProxyFailed(failedProxy *Proxy) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, proxy := range pm.proxies {
+		if proxy.URL == failedProxy.URL {
+			proxy.recordResult(false)
+			pm.recordFailure(proxy)
+			logger.Info("Marked proxy as failed: %s (fail count: %d, circuit %s)", proxy.URL, proxy.FailCount, proxy.State)
+			break
+		}
+	}
+}
+
+// MarkProxySuccess đánh dấu proxy thành công
+func (pm *ProxyManager) MarkProxySuccess(successProxy *Proxy) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, proxy := range pm.proxies {
+		if proxy.URL == successProxy.URL {
+			proxy.recordResult(true)
+			pm.recordSuccess(proxy)
+			logger.Info("Marked proxy as successful: %s", proxy.URL)
+			break
+		}
+	}
+}
+
+// RetireProxy loại proxy khỏi vòng chọn vĩnh viễn, trả về false nếu không tìm thấy
+func (pm *ProxyManager) RetireProxy(proxyURL string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, proxy := range pm.proxies {
+		if proxy.URL == proxyURL {
+			proxy.State = BreakerRetired
+			logger.Info("Retired proxy %s", proxy.URL)
+			return true
+		}
+	}
+	return false
+}
+
+// ReviveProxy đưa proxy (kể cả đã retired) về trạng thái closed, trả về false nếu không tìm thấy
+func (pm *ProxyManager) ReviveProxy(proxyURL string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, proxy := range pm.proxies {
+		if proxy.URL == proxyURL {
+			proxy.State = BreakerClosed
+			proxy.FailCount = 0
+			proxy.trips = 0
+			logger.Info("Revived proxy %s", proxy.URL)
+			return true
+		}
+	}
+	return false
+}
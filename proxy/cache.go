@@ -0,0 +1,451 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheConfig cấu hình cache response dùng chung
+type CacheConfig struct {
+	// Hosts danh sách mẫu host được bật cache: "example.com", "*.example.com" hoặc "*"
+	Hosts        []string `json:"hosts"`
+	ExcludeHosts []string `json:"exclude_hosts,omitempty"`
+
+	MaxMemoryBytes int64 `json:"max_memory_bytes,omitempty"`
+	MaxObjectBytes int64 `json:"max_object_bytes,omitempty"`
+
+	// Dir thư mục lưu cache trên đĩa, để trống nếu chỉ dùng bộ nhớ
+	Dir          string `json:"dir,omitempty"`
+	MaxDiskBytes int64  `json:"max_disk_bytes,omitempty"`
+}
+
+// CacheStats thống kê hoạt động của cache
+type CacheStats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Revalidated   int64 `json:"revalidated"`
+	Stores        int64 `json:"stores"`
+	Evictions     int64 `json:"evictions"`
+	MemoryEntries int   `json:"memory_entries"`
+	MemoryBytes   int64 `json:"memory_bytes"`
+	DiskEntries   int   `json:"disk_entries"`
+	DiskBytes     int64 `json:"disk_bytes"`
+}
+
+// cacheEntry là một response đã lưu, Body không được ghi vào metadata trên đĩa
+type cacheEntry struct {
+	Key          string      `json:"key"`
+	URL          string      `json:"url"`
+	Host         string      `json:"host"`
+	VaryNames    []string    `json:"vary_names,omitempty"`
+	StatusCode   int         `json:"status_code"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"-"`
+	RequestTime  time.Time   `json:"request_time"`
+	ResponseTime time.Time   `json:"response_time"`
+}
+
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.Body) + len(e.Key))
+}
+
+// httpCache là cache hai tầng: bộ nhớ (LRU) và đĩa
+type httpCache struct {
+	cfg CacheConfig
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	memBytes int64
+	vary     map[string][]string // URL -> tên các header trong Vary
+	disk     *diskStore
+
+	hits        atomic.Int64
+	misses      atomic.Int64
+	revalidated atomic.Int64
+	stores      atomic.Int64
+	evictions   atomic.Int64
+}
+
+// responseCache là cache đang dùng, nil khi tắt cache; được thay khi tải lại cấu hình
+var responseCache atomic.Pointer[httpCache]
+
+// ConfigureCache bật cache response theo cấu hình, nil để tắt. Cấu hình không đổi thì giữ cache
+// hiện tại cùng nội dung và thống kê của nó.
+func ConfigureCache(cfg *CacheConfig) error {
+	c, err := newHTTPCache(cfg)
+	if err != nil {
+		return err
+	}
+	setResponseCache(c)
+	return nil
+}
+
+// newHTTPCache tạo cache theo cấu hình mà chưa dùng nó, trả về cache hiện tại nếu cấu hình không đổi
+// và nil khi cfg nil
+func newHTTPCache(cfg *CacheConfig) (*httpCache, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	settings := *cfg
+	if settings.MaxMemoryBytes <= 0 {
+		settings.MaxMemoryBytes = 64 << 20
+	}
+	if settings.MaxObjectBytes <= 0 {
+		settings.MaxObjectBytes = 10 << 20
+	}
+	if settings.Dir != "" && settings.MaxDiskBytes <= 0 {
+		settings.MaxDiskBytes = 1 << 30
+	}
+	if current := responseCache.Load(); current != nil && reflect.DeepEqual(current.cfg, settings) {
+		return current, nil
+	}
+
+	c := &httpCache{
+		cfg:     settings,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		vary:    make(map[string][]string),
+	}
+
+	if cfg.Dir != "" {
+		disk, err := newDiskStore(cfg.Dir, c.cfg.MaxDiskBytes)
+		if err != nil {
+			return nil, err
+		}
+		c.disk = disk
+		for url, names := range disk.varyIndex() {
+			c.vary[url] = names
+		}
+	}
+
+	return c, nil
+}
+
+// setResponseCache dùng c cho các request mới, nil để tắt cache
+func setResponseCache(c *httpCache) {
+	if responseCache.Swap(c) != c && c != nil {
+		logger.Info("Response cache enabled for %d host patterns (memory: %d bytes, disk: %q)", len(c.cfg.Hosts), c.cfg.MaxMemoryBytes, c.cfg.Dir)
+	}
+}
+
+// enabledFor kiểm tra host có được bật cache hay không
+func (c *httpCache) enabledFor(host string) bool {
+	for _, pattern := range c.cfg.ExcludeHosts {
+		if matchHostPattern(pattern, host) {
+			return false
+		}
+	}
+	for _, pattern := range c.cfg.Hosts {
+		if matchHostPattern(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheURL chuẩn hóa URL của request làm khóa chính
+func cacheURL(req *http.Request) string {
+	u := *req.URL
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	return u.String()
+}
+
+// variantKey ghép URL với giá trị các header trong Vary để phân biệt các biến thể
+func variantKey(url string, names []string, req *http.Request) string {
+	var key strings.Builder
+	key.WriteString(url)
+	for _, name := range names {
+		key.WriteString("\x00")
+		key.WriteString(name)
+		key.WriteString("=")
+		key.WriteString(strings.Join(req.Header.Values(name), ","))
+	}
+	return key.String()
+}
+
+// parseVary trả về danh sách header trong Vary đã chuẩn hóa và sắp xếp
+func parseVary(header http.Header) []string {
+	var names []string
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// get tìm entry khớp request ở bộ nhớ rồi tới đĩa
+func (c *httpCache) get(req *http.Request) *cacheEntry {
+	url := cacheURL(req)
+
+	c.mu.Lock()
+	key := variantKey(url, c.vary[url], req)
+	if elem, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*cacheEntry)
+	}
+	c.mu.Unlock()
+
+	if c.disk == nil {
+		return nil
+	}
+
+	entry := c.disk.get(key)
+	if entry != nil {
+		c.putMemory(entry)
+	}
+	return entry
+}
+
+// put lưu entry vào bộ nhớ và đĩa
+func (c *httpCache) put(entry *cacheEntry) {
+	c.stores.Add(1)
+	c.putMemory(entry)
+
+	if c.disk != nil {
+		if err := c.disk.put(entry); err != nil {
+			logger.Error("Failed to write cache entry to disk: %v", err)
+		}
+	}
+}
+
+func (c *httpCache) putMemory(entry *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.vary[entry.URL] = entry.VaryNames
+	if elem, ok := c.entries[entry.Key]; ok {
+		c.memBytes -= elem.Value.(*cacheEntry).size()
+		c.lru.Remove(elem)
+	}
+
+	c.entries[entry.Key] = c.lru.PushFront(entry)
+	c.memBytes += entry.size()
+
+	for c.memBytes > c.cfg.MaxMemoryBytes && c.lru.Len() > 0 {
+		oldest := c.lru.Back()
+		old := oldest.Value.(*cacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.entries, old.Key)
+		c.memBytes -= old.size()
+		c.evictions.Add(1)
+	}
+}
+
+// purge xóa các entry theo URL, theo host hoặc toàn bộ khi cả hai để trống
+func (c *httpCache) purge(host, url string) int {
+	match := func(entryHost, entryURL string) bool {
+		if url != "" {
+			return entryURL == url
+		}
+		if host != "" {
+			return strings.EqualFold(entryHost, host)
+		}
+		return true
+	}
+
+	c.mu.Lock()
+	removed := 0
+	for key, elem := range c.entries {
+		entry := elem.Value.(*cacheEntry)
+		if match(entry.Host, entry.URL) {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+			c.memBytes -= entry.size()
+			removed++
+		}
+	}
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		if diskRemoved := c.disk.purge(match); diskRemoved > removed {
+			removed = diskRemoved
+		}
+	}
+
+	logger.Info("Purged %d cache entries (host: %q, url: %q)", removed, host, url)
+	return removed
+}
+
+// Stats trả về thống kê hiện tại của cache
+func (c *httpCache) Stats() CacheStats {
+	c.mu.Lock()
+	stats := CacheStats{
+		Hits:          c.hits.Load(),
+		Misses:        c.misses.Load(),
+		Revalidated:   c.revalidated.Load(),
+		Stores:        c.stores.Load(),
+		Evictions:     c.evictions.Load(),
+		MemoryEntries: c.lru.Len(),
+		MemoryBytes:   c.memBytes,
+	}
+	c.mu.Unlock()
+
+	if c.disk != nil {
+		var diskEvictions int64
+		stats.DiskEntries, stats.DiskBytes, diskEvictions = c.disk.stats()
+		stats.Evictions += diskEvictions
+	}
+	return stats
+}
+
+// response dựng http.Response từ entry đã lưu
+func (e *cacheEntry) response(req *http.Request, status string, now time.Time) *http.Response {
+	header := e.Header.Clone()
+	header.Set("Age", strconv.FormatInt(int64(e.currentAge(now)/time.Second), 10))
+	header.Set("X-Cache", status)
+
+	statusCode := e.StatusCode
+	body := e.Body
+
+	// Client gửi kèm ETag đã có, trả 304 thay vì toàn bộ nội dung
+	if etag := e.Header.Get("ETag"); etag != "" && req.Header.Get("If-None-Match") == etag {
+		statusCode = http.StatusNotModified
+		body = nil
+	}
+
+	// HEAD dùng chung entry với GET nhưng không nhận body, Content-Length vẫn là độ dài của GET
+	contentLength := int64(len(body))
+	if req.Method == http.MethodHead {
+		body = nil
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: contentLength,
+		Request:       req,
+	}
+}
+
+// roundTripWithCache gửi request qua ProxyTransport, phục vụ từ cache nếu có thể
+func roundTripWithCache(t *ProxyTransport, req *http.Request) (*http.Response, error) {
+	c := responseCache.Load()
+	if c == nil || !c.enabledFor(req.URL.Hostname()) {
+		return t.RoundTrip(req, nil)
+	}
+
+	// Request thay đổi tài nguyên làm entry hiện có không còn hợp lệ
+	if isUnsafeMethod(req.Method) {
+		resp, err := t.RoundTrip(req, nil)
+		if err == nil && resp.StatusCode < 400 {
+			c.purge("", cacheURL(req))
+		}
+		return resp, err
+	}
+
+	if !isCacheableRequest(req) {
+		return t.RoundTrip(req, nil)
+	}
+
+	now := time.Now()
+	entry := c.get(req)
+	if entry != nil && entry.canServeWithoutValidation(req, now) {
+		c.hits.Add(1)
+		logger.Info("Cache HIT %s", req.URL.String())
+		return entry.response(req, "HIT", now), nil
+	}
+
+	// Không có entry để trả thay: request Range hoặc điều kiện của client đi thẳng tới origin
+	if entry == nil && isPartialOrConditional(req) {
+		return t.RoundTrip(req, nil)
+	}
+
+	// Entry đã cũ, gửi request điều kiện để xác thực lại
+	outReq := req
+	if entry != nil && entry.hasValidators() {
+		outReq = req.Clone(req.Context())
+		entry.addValidators(outReq)
+	}
+
+	requestTime := time.Now()
+	resp, err := t.RoundTrip(outReq, nil)
+	if err != nil {
+		return nil, err
+	}
+	responseTime := time.Now()
+
+	if outReq != req && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+
+		updated := *entry
+		updated.Header = entry.Header.Clone()
+		for key, values := range resp.Header {
+			if key != "Content-Length" {
+				updated.Header[key] = values
+			}
+		}
+		updated.RequestTime = requestTime
+		updated.ResponseTime = responseTime
+		c.put(&updated)
+
+		c.revalidated.Add(1)
+		logger.Info("Cache REVALIDATED %s", req.URL.String())
+		return updated.response(req, "REVALIDATED", responseTime), nil
+	}
+
+	c.misses.Add(1)
+	resp.Header.Set("X-Cache", "MISS")
+	if !isStorableResponse(req, resp) {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, c.cfg.MaxObjectBytes+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	// Response quá lớn: trả tiếp phần còn lại mà không lưu
+	if int64(len(body)) > c.cfg.MaxObjectBytes {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	url := cacheURL(req)
+	varyNames := parseVary(resp.Header)
+	header := resp.Header.Clone()
+	header.Del("X-Cache")
+	c.put(&cacheEntry{
+		Key:          variantKey(url, varyNames, req),
+		URL:          url,
+		Host:         strings.ToLower(req.URL.Hostname()),
+		VaryNames:    varyNames,
+		StatusCode:   resp.StatusCode,
+		Header:       header,
+		Body:         body,
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	})
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+	logger.Info("Cache MISS %s (stored %d bytes)", req.URL.String(), len(body))
+	return resp, nil
+}
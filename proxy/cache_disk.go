@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// diskEntryInfo là thông tin một entry trên đĩa được giữ trong bộ nhớ để tra cứu và dọn dẹp
+type diskEntryInfo struct {
+	file      string
+	url       string
+	host      string
+	varyNames []string
+	size      int64
+	accessed  time.Time
+}
+
+// diskStore lưu entry cache dưới dạng file: một dòng metadata JSON, theo sau là body
+type diskStore struct {
+	dir      string
+	maxBytes int64
+
+	mu        sync.Mutex
+	index     map[string]*diskEntryInfo
+	bytes     int64
+	evictions int64
+}
+
+// newDiskStore mở thư mục cache và dựng lại chỉ mục từ các file có sẵn
+func newDiskStore(dir string, maxBytes int64) (*diskStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache dir %s: %v", dir, err)
+	}
+
+	d := &diskStore{
+		dir:      dir,
+		maxBytes: maxBytes,
+		index:    make(map[string]*diskEntryInfo),
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		entry, size, err := readCacheFile(file, false)
+		if err != nil {
+			logger.Error("Removing unreadable cache file %s: %v", file, err)
+			os.Remove(file)
+			continue
+		}
+
+		d.index[entry.Key] = &diskEntryInfo{
+			file:      file,
+			url:       entry.URL,
+			host:      entry.Host,
+			varyNames: entry.VaryNames,
+			size:      size,
+			accessed:  entry.ResponseTime,
+		}
+		d.bytes += size
+	}
+
+	d.evict()
+	return d, nil
+}
+
+func (d *diskStore) fileFor(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".cache")
+}
+
+// readCacheFile đọc metadata và (tùy chọn) body của một file cache
+func readCacheFile(file string, withBody bool) (*cacheEntry, int64, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	reader := bufio.NewReader(f)
+	meta, err := reader.ReadBytes('\n')
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(meta, &entry); err != nil {
+		return nil, 0, err
+	}
+
+	if withBody {
+		if entry.Body, err = io.ReadAll(reader); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return &entry, stat.Size(), nil
+}
+
+// varyIndex trả về danh sách header Vary theo URL của các entry trên đĩa
+func (d *diskStore) varyIndex() map[string][]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	vary := make(map[string][]string)
+	for _, info := range d.index {
+		vary[info.url] = info.varyNames
+	}
+	return vary
+}
+
+func (d *diskStore) get(key string) *cacheEntry {
+	d.mu.Lock()
+	info, ok := d.index[key]
+	if ok {
+		info.accessed = time.Now()
+	}
+	d.mu.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	entry, _, err := readCacheFile(info.file, true)
+	if err != nil {
+		logger.Error("Failed to read cache file %s: %v", info.file, err)
+		d.remove(key)
+		return nil
+	}
+	return entry
+}
+
+// put ghi entry ra file tạm rồi đổi tên để không bao giờ đọc phải file ghi dở
+func (d *diskStore) put(entry *cacheEntry) error {
+	meta, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	file := d.fileFor(entry.Key)
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(meta)
+	buf.WriteByte('\n')
+	buf.Write(entry.Body)
+
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if old, ok := d.index[entry.Key]; ok {
+		d.bytes -= old.size
+	}
+	d.index[entry.Key] = &diskEntryInfo{
+		file:      file,
+		url:       entry.URL,
+		host:      entry.Host,
+		varyNames: entry.VaryNames,
+		size:      int64(buf.Len()),
+		accessed:  time.Now(),
+	}
+	d.bytes += int64(buf.Len())
+
+	d.evict()
+	return nil
+}
+
+func (d *diskStore) remove(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.removeLocked(key)
+}
+
+func (d *diskStore) removeLocked(key string) {
+	info, ok := d.index[key]
+	if !ok {
+		return
+	}
+	os.Remove(info.file)
+	d.bytes -= info.size
+	delete(d.index, key)
+}
+
+// evict xóa các entry ít được truy cập nhất cho tới khi dưới giới hạn dung lượng
+func (d *diskStore) evict() {
+	if d.bytes <= d.maxBytes {
+		return
+	}
+
+	keys := make([]string, 0, len(d.index))
+	for key := range d.index {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return d.index[keys[i]].accessed.Before(d.index[keys[j]].accessed)
+	})
+
+	for _, key := range keys {
+		if d.bytes <= d.maxBytes {
+			break
+		}
+		d.removeLocked(key)
+		d.evictions++
+	}
+}
+
+// purge xóa các entry khớp điều kiện, trả về số entry đã xóa
+func (d *diskStore) purge(match func(host, url string) bool) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	removed := 0
+	for key, info := range d.index {
+		if match(info.host, info.url) {
+			d.removeLocked(key)
+			removed++
+		}
+	}
+
+	return removed
+}
+
+func (d *diskStore) stats() (int, int64, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.index), d.bytes, d.evictions
+}
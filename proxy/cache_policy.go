@@ -0,0 +1,203 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Các mã trạng thái được phép lưu theo heuristic (RFC 9110 mục 15.1)
+var heuristicStatusCodes = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// cacheControl là tập directive của header Cache-Control
+type cacheControl map[string]string
+
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if directive == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(directive, "=")
+			cc[strings.ToLower(strings.TrimSpace(name))] = strings.Trim(strings.TrimSpace(arg), `"`)
+		}
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds trả về giá trị giây của directive, ok = false nếu không có hoặc sai định dạng
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// isCacheableRequest kiểm tra request có thể được phục vụ từ cache hay không
+func isCacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	return !parseCacheControl(req.Header).has("no-store")
+}
+
+// isPartialOrConditional cho biết request chỉ xin một phần hoặc chỉ xin xác nhận bản client đang có
+func isPartialOrConditional(req *http.Request) bool {
+	return req.Header.Get("Range") != "" || req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != ""
+}
+
+// isStorableResponse áp dụng các điều kiện lưu trữ của shared cache (RFC 9111 mục 3)
+func isStorableResponse(req *http.Request, resp *http.Response) bool {
+	if req.Method != http.MethodGet {
+		return false
+	}
+	// 304 và 206 chỉ là câu trả lời cho request điều kiện hoặc Range, không phục vụ được request thường
+	if resp.StatusCode == http.StatusNotModified || resp.StatusCode == http.StatusPartialContent {
+		return false
+	}
+
+	cc := parseCacheControl(resp.Header)
+	if cc.has("no-store") || cc.has("private") {
+		return false
+	}
+	if strings.TrimSpace(resp.Header.Get("Vary")) == "*" {
+		return false
+	}
+	if resp.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+
+	// Request có Authorization chỉ được lưu khi origin cho phép rõ ràng
+	if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return false
+	}
+
+	if cc.has("max-age") || cc.has("s-maxage") || resp.Header.Get("Expires") != "" {
+		return resp.StatusCode < 500 || resp.StatusCode == 501
+	}
+
+	return heuristicStatusCodes[resp.StatusCode] && (cc.has("public") || resp.Header.Get("Last-Modified") != "")
+}
+
+// freshnessLifetime tính thời gian còn mới của response (RFC 9111 mục 4.2.1)
+func freshnessLifetime(header http.Header) time.Duration {
+	cc := parseCacheControl(header)
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+
+	date, dateErr := http.ParseTime(header.Get("Date"))
+	if expiresValue := header.Get("Expires"); expiresValue != "" {
+		expires, err := http.ParseTime(expiresValue)
+		if err != nil || dateErr != nil {
+			return 0
+		}
+		return expires.Sub(date)
+	}
+
+	// Heuristic: 10% thời gian kể từ Last-Modified, tối đa 24 giờ
+	if lastModified, err := http.ParseTime(header.Get("Last-Modified")); err == nil && dateErr == nil {
+		heuristic := date.Sub(lastModified) / 10
+		if heuristic > 24*time.Hour {
+			heuristic = 24 * time.Hour
+		}
+		if heuristic > 0 {
+			return heuristic
+		}
+	}
+
+	return 0
+}
+
+// currentAge tính tuổi hiện tại của response đã lưu (RFC 9111 mục 4.2.3)
+func (e *cacheEntry) currentAge(now time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(e.Header.Get("Date")); err == nil {
+		apparentAge = e.ResponseTime.Sub(date)
+		if apparentAge < 0 {
+			apparentAge = 0
+		}
+	}
+
+	ageValue, _ := strconv.ParseInt(e.Header.Get("Age"), 10, 64)
+	correctedAge := time.Duration(ageValue)*time.Second + e.ResponseTime.Sub(e.RequestTime)
+
+	initialAge := apparentAge
+	if correctedAge > initialAge {
+		initialAge = correctedAge
+	}
+
+	return initialAge + now.Sub(e.ResponseTime)
+}
+
+// canServeWithoutValidation kiểm tra entry còn mới và thỏa các ràng buộc của request
+func (e *cacheEntry) canServeWithoutValidation(req *http.Request, now time.Time) bool {
+	respCC := parseCacheControl(e.Header)
+	reqCC := parseCacheControl(req.Header)
+	if respCC.has("no-cache") || reqCC.has("no-cache") || strings.Contains(req.Header.Get("Pragma"), "no-cache") {
+		return false
+	}
+
+	age := e.currentAge(now)
+	lifetime := freshnessLifetime(e.Header)
+
+	if maxAge, ok := reqCC.seconds("max-age"); ok && age > maxAge {
+		return false
+	}
+	if minFresh, ok := reqCC.seconds("min-fresh"); ok {
+		lifetime -= minFresh
+	}
+	if age < lifetime {
+		return true
+	}
+
+	// Cho phép dùng response cũ khi client chấp nhận max-stale và origin không cấm
+	if respCC.has("must-revalidate") || respCC.has("proxy-revalidate") || respCC.has("s-maxage") || !reqCC.has("max-stale") {
+		return false
+	}
+	maxStale, ok := reqCC.seconds("max-stale")
+	return !ok || age-lifetime <= maxStale
+}
+
+// addValidators thêm header điều kiện để xác thực lại entry với origin
+func (e *cacheEntry) addValidators(req *http.Request) {
+	if etag := e.Header.Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := e.Header.Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// hasValidators kiểm tra entry có ETag hoặc Last-Modified để xác thực lại
+func (e *cacheEntry) hasValidators() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// isUnsafeMethod kiểm tra method làm thay đổi tài nguyên, cần vô hiệu hóa cache
+func isUnsafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return false
+	}
+	return true
+}
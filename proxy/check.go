@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Loại vấn đề phát hiện khi kiểm tra danh sách proxy
+const (
+	IssueMalformed    = "malformed"
+	IssueDuplicate    = "duplicate"
+	IssueTypeMismatch = "type-mismatch"
+)
+
+// ListEntry là một proxy hợp lệ trong danh sách được kiểm tra
+type ListEntry struct {
+	Source string
+	Line   int // Số dòng (text, yaml, csv) hoặc vị trí phần tử (json), 0 nếu không rõ
+	Proxy  *Proxy
+}
+
+// ListIssue là một vấn đề trong danh sách, không chứa thông tin đăng nhập
+type ListIssue struct {
+	Source  string
+	Line    int
+	Kind    string
+	Message string
+}
+
+// ListValidator kiểm tra các danh sách proxy và phát hiện proxy trùng giữa các danh sách
+type ListValidator struct {
+	// Type loại proxy cho mục không khai báo scheme
+	Type ProxyType
+	// StrictType báo type-mismatch cho mục khai báo scheme khác Type
+	StrictType bool
+
+	seen map[string]*ListEntry
+}
+
+// Validate phân tích danh sách theo định dạng (xem ParseProxyData), trả về các proxy không trùng
+// theo thứ tự trong danh sách cùng các vấn đề tìm thấy
+func (v *ListValidator) Validate(source string, data []byte, format string) ([]*ListEntry, []ListIssue, error) {
+	if v.seen == nil {
+		v.seen = make(map[string]*ListEntry)
+	}
+
+	var proxies []*Proxy
+	var lines []int
+	var lineErrors []*LineError
+	var err error
+	if format == "" || format == FormatText {
+		proxies, lines, lineErrors, err = parseProxyLines(bytes.NewReader(data), ProxyTypeUnknown)
+	} else {
+		proxies, lineErrors, err = ParseProxyData(data, format, ProxyTypeUnknown)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var issues []ListIssue
+	for _, lineErr := range lineErrors {
+		issues = append(issues, ListIssue{Source: source, Line: lineErr.Line, Kind: IssueMalformed, Message: lineErr.Err.Error()})
+	}
+
+	var entries []*ListEntry
+	for i, proxy := range proxies {
+		entry := &ListEntry{Source: source, Proxy: proxy}
+		if lines != nil {
+			entry.Line = lines[i]
+		}
+
+		if proxy.Type != ProxyTypeUnknown && v.StrictType && v.Type != ProxyTypeUnknown && proxy.Type != v.Type {
+			issues = append(issues, ListIssue{Source: source, Line: entry.Line, Kind: IssueTypeMismatch,
+				Message: fmt.Sprintf("%s declared as %s in a %s list", proxy.URL, proxy.Type, v.Type)})
+		}
+		proxy.applyDefaultType(v.Type)
+
+		if first, ok := v.seen[proxy.URL]; ok {
+			message := fmt.Sprintf("%s already listed at %s", proxy.URL, first.position())
+			if first.Proxy.Username != proxy.Username || first.Proxy.Password != proxy.Password {
+				message += " with different credentials"
+			}
+			issues = append(issues, ListIssue{Source: source, Line: entry.Line, Kind: IssueDuplicate, Message: message})
+			continue
+		}
+		v.seen[proxy.URL] = entry
+		entries = append(entries, entry)
+	}
+	return entries, issues, nil
+}
+
+// position mô tả vị trí của mục trong danh sách
+func (e *ListEntry) position() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d", e.Source, e.Line)
+	}
+	return e.Source
+}
+
+// CheckOptions cấu hình kiểm tra proxy
+type CheckOptions struct {
+	// Target URL được request qua từng proxy; judge kiểu httpbin.org/get cho biết thêm IP đầu ra
+	Target      string
+	Timeout     time.Duration
+	Concurrency int
+}
+
+// CheckResult là kết quả kiểm tra một proxy
+type CheckResult struct {
+	Entry   *ListEntry
+	Latency time.Duration
+	ExitIP  string
+	// DetectedType loại proxy thực sự hoạt động, khác Entry.Proxy.Type khi proxy bị khai báo sai loại
+	DetectedType ProxyType
+	Err          error
+}
+
+// Working cho biết proxy hoạt động
+func (r CheckResult) Working() bool {
+	return r.Err == nil
+}
+
+// Mismatch cho biết proxy chỉ hoạt động với loại khác loại khai báo
+func (r CheckResult) Mismatch() bool {
+	return r.Err == nil && r.DetectedType != r.Entry.Proxy.Type
+}
+
+// CheckProxies kiểm tra đồng thời các proxy, kết quả theo thứ tự của entries. Proxy HTTP hoặc SOCKS5
+// không hoạt động được thử lại với loại còn lại để phát hiện khai báo sai loại.
+func CheckProxies(entries []*ListEntry, opts CheckOptions) []CheckResult {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+
+	results := make([]CheckResult, len(entries))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = checkEntry(entries[i], opts)
+			}
+		}()
+	}
+	for i := range entries {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results
+}
+
+func checkEntry(entry *ListEntry, opts CheckOptions) CheckResult {
+	proxy := entry.Proxy
+	result := CheckResult{Entry: entry, DetectedType: proxy.Type}
+	result.Latency, result.ExitIP, result.Err = probeProxy(proxy, opts)
+	if result.Err == nil {
+		return result
+	}
+
+	var alternative ProxyType
+	switch proxy.Type {
+	case ProxyTypeHTTP:
+		alternative = ProxyTypeSOCKS5
+	case ProxyTypeSOCKS5:
+		alternative = ProxyTypeHTTP
+	default:
+		return result
+	}
+	alt := &Proxy{
+		URL:      string(alternative) + "://" + proxyAddress(proxy.URL),
+		Username: proxy.Username,
+		Password: proxy.Password,
+		Type:     alternative,
+	}
+	if latency, exitIP, err := probeProxy(alt, opts); err == nil {
+		result.Latency, result.ExitIP, result.Err = latency, exitIP, nil
+		result.DetectedType = alternative
+	}
+	return result
+}
+
+// probeProxy gửi một request tới target qua proxy, trả về độ trễ và IP đầu ra nếu target là judge
+func probeProxy(proxy *Proxy, opts CheckOptions) (time.Duration, string, error) {
+	transport, err := checkTransport(proxy)
+	if err != nil {
+		return 0, "", err
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: opts.Timeout}
+
+	start := time.Now()
+	resp, err := client.Get(opts.Target)
+	if err != nil {
+		// Bỏ URL của target khỏi lỗi cho báo cáo gọn hơn
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	latency := time.Since(start)
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to read response: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return 0, "", fmt.Errorf("target returned status %d", resp.StatusCode)
+	}
+
+	judge := parseJudgeJSON(body)
+	if judge == nil {
+		judge = parseJudgeText(string(body))
+	}
+	return latency, judge.exitIP, nil
+}
+
+// WriteCheckedList ghi các proxy hoạt động dạng CSV (url, type, latency_ms, exit_ip) theo loại phát hiện được,
+// proxy trùng sau khi sửa loại chỉ ghi một lần. File này dùng được làm inventory, các cột latency_ms và
+// exit_ip được bỏ qua khi tải. Trả về số proxy đã ghi.
+func WriteCheckedList(w io.Writer, results []CheckResult) (int, error) {
+	writer := csv.NewWriter(w)
+	if err := writer.Write([]string{"url", "type", "latency_ms", "exit_ip"}); err != nil {
+		return 0, err
+	}
+	written := make(map[url.URL]bool)
+	for _, result := range results {
+		if !result.Working() {
+			continue
+		}
+		proxy := result.Entry.Proxy
+		u := &url.URL{Scheme: string(result.DetectedType), Host: proxyAddress(proxy.URL)}
+		if written[*u] {
+			continue
+		}
+		written[*u] = true
+		// Giữ nguyên tham chiếu bí mật để danh sách ghi ra không chứa mật khẩu
+		if proxy.passwordRef != "" {
+			u.User = url.UserPassword(proxy.usernameRef, proxy.passwordRef)
+		} else if proxy.usernameRef != "" {
+			u.User = url.User(proxy.usernameRef)
+		}
+		row := []string{u.String(), string(result.DetectedType), strconv.FormatInt(result.Latency.Milliseconds(), 10), result.ExitIP}
+		if err := writer.Write(row); err != nil {
+			return 0, err
+		}
+	}
+	writer.Flush()
+	return len(written), writer.Error()
+}
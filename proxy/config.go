@@ -0,0 +1,311 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ListenerMode định nghĩa chế độ hoạt động của một listener
+type ListenerMode string
+
+const (
+	ListenerModeProxy       ListenerMode = "proxy"
+	ListenerModeTransparent ListenerMode = "transparent"
+	ListenerModeGateway     ListenerMode = "gateway"
+)
+
+// ListenerConfig cấu hình cho một cổng lắng nghe
+type ListenerConfig struct {
+	Addr string       `json:"addr"`
+	Mode ListenerMode `json:"mode"`
+
+	// TProxy bật chế độ TPROXY cho listener transparent: địa chỉ đích gốc
+	// chính là địa chỉ local của kết nối thay vì đọc từ SO_ORIGINAL_DST
+	TProxy bool `json:"tproxy,omitempty"`
+
+	// TLS bật mã hóa giữa client và listener proxy
+	TLS *TLSConfig `json:"tls,omitempty"`
+
+	// Routes danh sách origin cho listener gateway
+	Routes []GatewayRoute `json:"routes,omitempty"`
+
+	// Strategy ghi đè strategy chọn proxy của pool cho riêng listener này
+	Strategy string `json:"strategy,omitempty"`
+
+	// Filter chỉ dùng các proxy thỏa bộ lọc GeoIP cho listener này
+	Filter *ProxyFilter `json:"filter,omitempty"`
+}
+
+// Config cấu hình tổng của proxy server
+type Config struct {
+	HTTPProxyFile   string           `json:"http_proxy_file"`
+	SOCKS5ProxyFile string           `json:"socks5_proxy_file"`
+	Listeners       []ListenerConfig `json:"listeners"`
+
+	// InventoryFiles các file inventory (.json, .yaml, .csv) chứa proxy kèm metadata
+	InventoryFiles []string `json:"inventory_files,omitempty"`
+
+	// RetryStatusCodes mã trạng thái khiến request được thử lại với proxy khác
+	RetryStatusCodes []int        `json:"retry_status_codes,omitempty"`
+	HeaderRules      *HeaderRules `json:"header_rules,omitempty"`
+	MITM             *MITMConfig  `json:"mitm,omitempty"`
+	Cache            *CacheConfig `json:"cache,omitempty"`
+	API              *APIConfig   `json:"api,omitempty"`
+
+	// Strategy cách chọn proxy mặc định của pool, để trống thì giữ cách chọn ngẫu nhiên cũ
+	Strategy string `json:"strategy,omitempty"`
+
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+	HealthCheck    *HealthCheckConfig    `json:"health_check,omitempty"`
+	Limits         *LimitsConfig         `json:"limits,omitempty"`
+	Bans           *BanConfig            `json:"bans,omitempty"`
+	State          *StateConfig          `json:"state,omitempty"`
+	GeoIP          *GeoIPConfig          `json:"geoip,omitempty"`
+
+	// Subscriptions các danh sách proxy tải định kỳ qua HTTP
+	Subscriptions []SubscriptionConfig `json:"subscriptions,omitempty"`
+	// Providers các nhà cung cấp proxy động, pool được bổ sung cho đủ số lượng theo target
+	Providers []ProviderConfig `json:"providers,omitempty"`
+	// GatewayUpstreams các gateway mà username chọn IP đầu ra, mỗi session là một proxy ảo
+	GatewayUpstreams []GatewayUpstreamConfig `json:"gateway_upstreams,omitempty"`
+	// Secrets file bí mật cho tham chiếu ${secrets.NAME} trong danh sách proxy và cấu hình, có thể mã hóa
+	Secrets *SecretsConfig `json:"secrets,omitempty"`
+
+	// Pools các pool proxy có tên dùng trong luật định tuyến
+	Pools []PoolConfig `json:"pools,omitempty"`
+	// Routing bảng luật định tuyến theo đích và client, xét theo thứ tự
+	Routing []RoutingRule `json:"routing,omitempty"`
+
+	// ProxyWeights trọng số theo địa chỉ proxy ("host:port") cho strategy weighted-random
+	ProxyWeights map[string]int `json:"proxy_weights,omitempty"`
+}
+
+// DefaultConfig trả về cấu hình mặc định
+func DefaultConfig() *Config {
+	return &Config{
+		HTTPProxyFile:   "proxy_http.txt",
+		SOCKS5ProxyFile: "proxy_sockets5.txt",
+		Listeners: []ListenerConfig{
+			{Addr: ":8081", Mode: ListenerModeProxy},
+		},
+	}
+}
+
+// LoadConfig đọc cấu hình từ file JSON, các trường không khai báo giữ giá trị mặc định
+func LoadConfig(filename string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file %s: %v", filename, err)
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %v", filename, err)
+	}
+
+	for i := range cfg.Listeners {
+		if cfg.Listeners[i].Mode == "" {
+			cfg.Listeners[i].Mode = ListenerModeProxy
+		}
+	}
+
+	return cfg, nil
+}
+
+// ApplyConfig áp dụng các thiết lập chung của cấu hình lên proxy manager
+func ApplyConfig(pm *ProxyManager, cfg *Config) error {
+	rc, err := compileRuntimeConfig(pm, nil, cfg, false)
+	if err != nil {
+		return err
+	}
+	rc.apply()
+	return nil
+}
+
+// ReloadConfig áp lại cấu hình mới lúc đang chạy (SIGHUP): thiết lập của pool mặc định và các pool có
+// file riêng, bảng định tuyến, file bí mật, GeoIP, MITM và cache. Listener, API, danh sách pool, file proxy và
+// lưu trạng thái chỉ thay đổi khi khởi động lại. Mọi phần được kiểm tra và dựng xong trước khi áp dụng nên
+// cấu hình lỗi không làm thay đổi gì.
+func ReloadConfig(pm *ProxyManager, pools map[string]*ProxyManager, cfg *Config) error {
+	rc, err := compileRuntimeConfig(pm, pools, cfg, true)
+	if err != nil {
+		return err
+	}
+	rc.apply()
+
+	// Tra lại vị trí của các proxy hiện có theo cơ sở dữ liệu GeoIP vừa mở
+	pm.EnrichGeo()
+	for pool := range rc.pools {
+		pool.EnrichGeo()
+	}
+	return nil
+}
+
+// runtimeConfig là các phần cấu hình áp được lúc đang chạy, đã được kiểm tra và dựng sẵn
+type runtimeConfig struct {
+	secrets   map[string]string
+	settings  *poolSettings
+	pm        *ProxyManager
+	pools     map[*ProxyManager]*poolSettings // Các pool có file riêng
+	routes    *router
+	hasRoutes bool // routes chỉ được áp khi tải lại, lúc khởi động bảng định tuyến được dựng sau LoadPools
+	mitm      *mitmInterceptor
+	cache     *httpCache
+	geoIP     *geoIPDB
+}
+
+// compileRuntimeConfig kiểm tra và dựng mọi phần cấu hình mà chưa áp dụng phần nào. withRoutes bật việc
+// dựng thiết lập của các pool có tên và bảng định tuyến.
+func compileRuntimeConfig(pm *ProxyManager, pools map[string]*ProxyManager, cfg *Config, withRoutes bool) (*runtimeConfig, error) {
+	rc := &runtimeConfig{pm: pm, pools: make(map[*ProxyManager]*poolSettings), hasRoutes: withRoutes}
+
+	var err error
+	if rc.secrets, err = loadSecrets(cfg.Secrets); err != nil {
+		return nil, err
+	}
+	if rc.settings, err = compilePoolSettings(cfg); err != nil {
+		return nil, err
+	}
+
+	if withRoutes {
+		for _, pc := range cfg.Pools {
+			pool, ok := pools[pc.Name]
+			if !ok || !pc.HasProxyFiles() {
+				continue
+			}
+			settings, err := compilePoolSettings(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("pool %s: %v", pc.Name, err)
+			}
+			rc.pools[pool] = settings
+		}
+		if rc.routes, err = compileRoutes(cfg.Routing, pools); err != nil {
+			return nil, err
+		}
+	}
+
+	if rc.mitm, err = newMITMInterceptor(cfg.MITM); err != nil {
+		return nil, err
+	}
+	if rc.cache, err = newHTTPCache(cfg.Cache); err != nil {
+		return nil, err
+	}
+	// Mở GeoIP sau cùng để không phải đóng lại reader khi một phần khác lỗi
+	if rc.geoIP, err = openGeoIP(cfg.GeoIP); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// apply áp dụng cấu hình đã dựng, không thể lỗi
+func (rc *runtimeConfig) apply() {
+	// Nạp bí mật trước để các danh sách proxy tải sau đó thay được tham chiếu ${secrets.NAME}
+	setSecrets(rc.secrets)
+
+	rc.pm.applyPoolSettings(rc.settings)
+	for pool, settings := range rc.pools {
+		pool.applyPoolSettings(settings)
+	}
+	if rc.hasRoutes {
+		setRoutes(rc.routes)
+	}
+
+	replaceGeoIP(rc.geoIP)
+	setMITM(rc.mitm)
+	setResponseCache(rc.cache)
+}
+
+// poolSettings là các thiết lập riêng của một pool đã được kiểm tra
+type poolSettings struct {
+	retryStatusCodes []int
+	headerRules      *HeaderRules
+	weights          map[string]int
+	maxFails         int
+	breaker          breakerSettings
+	limits           *poolLimits
+	banDuration      time.Duration
+	strategy         Strategy // nil thì giữ strategy hiện tại
+}
+
+// compilePoolSettings kiểm tra các thiết lập riêng cho từng pool. Mỗi pool cần bản riêng vì giới hạn
+// theo nhóm credential được đếm theo pool.
+func compilePoolSettings(cfg *Config) (*poolSettings, error) {
+	settings := &poolSettings{
+		retryStatusCodes: cfg.RetryStatusCodes,
+		headerRules:      cfg.HeaderRules,
+		weights:          cfg.ProxyWeights,
+	}
+
+	var err error
+	if settings.maxFails, settings.breaker, err = parseCircuitBreaker(cfg.CircuitBreaker); err != nil {
+		return nil, err
+	}
+	if settings.limits, err = parseLimits(cfg.Limits); err != nil {
+		return nil, err
+	}
+	if settings.banDuration, err = parseBanDuration(cfg.Bans); err != nil {
+		return nil, err
+	}
+	if cfg.Strategy != "" {
+		if settings.strategy, err = NewStrategy(cfg.Strategy); err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
+
+// applyPoolSettings áp dụng các thiết lập riêng cho pool
+func (pm *ProxyManager) applyPoolSettings(settings *poolSettings) {
+	pm.SetRetryStatusCodes(settings.retryStatusCodes)
+	pm.SetHeaderRules(settings.headerRules)
+	pm.SetWeights(settings.weights)
+	pm.setLimits(settings.limits)
+
+	pm.mu.Lock()
+	pm.maxFails = settings.maxFails
+	pm.breaker = settings.breaker
+	pm.banDuration = settings.banDuration
+	if settings.strategy != nil {
+		pm.strategy = settings.strategy
+	}
+	pm.mu.Unlock()
+}
+
+// applyPoolSettings kiểm tra rồi áp dụng các thiết lập riêng cho pool có tên khi được tạo
+func applyPoolSettings(pm *ProxyManager, cfg *Config) error {
+	settings, err := compilePoolSettings(cfg)
+	if err != nil {
+		return err
+	}
+	pm.applyPoolSettings(settings)
+	return nil
+}
+
+// StartListener khởi động listener theo chế độ được cấu hình
+func StartListener(pm *ProxyManager, lc ListenerConfig) error {
+	if lc.Strategy != "" {
+		strategy, err := NewStrategy(lc.Strategy)
+		if err != nil {
+			return fmt.Errorf("listener %s: %v", lc.Addr, err)
+		}
+		pm = pm.WithStrategy(strategy)
+	}
+	pm = pm.WithFilter(lc.Filter)
+
+	switch lc.Mode {
+	case ListenerModeProxy:
+		if lc.TLS != nil {
+			return StartTLSProxyServer(pm, lc)
+		}
+		return StartProxyServer(pm, lc.Addr)
+	case ListenerModeTransparent:
+		return StartTransparentServer(pm, lc)
+	case ListenerModeGateway:
+		return StartGatewayServer(pm, lc)
+	default:
+		return fmt.Errorf("unknown listener mode %q on %s", lc.Mode, lc.Addr)
+	}
+}
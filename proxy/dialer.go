@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// httpOnlySelector chỉ chọn proxy HTTP (hỗ trợ CONNECT)
+func httpOnlySelector(p *Proxy) bool {
+	return p.Type == ProxyTypeHTTP || p.Type == ProxyTypeHTTPS || p.Type == ProxyTypeUnknown
+}
+
+// dialProxy mở kết nối TCP tới proxy, bắt tay TLS nếu là proxy HTTPS
+func dialProxy(proxy *Proxy) (net.Conn, error) {
+	address := proxyAddress(proxy.URL)
+	conn, err := net.DialTimeout("tcp", address, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if proxy.Type != ProxyTypeHTTPS {
+		return conn, nil
+	}
+
+	host, _, _ := net.SplitHostPort(address)
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: host})
+	if err := tlsConn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := tlsConn.Handshake(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("TLS handshake with proxy failed: %v", err)
+	}
+	if err := tlsConn.SetDeadline(time.Time{}); err != nil {
+		logger.Error("Failed to reset deadline: %v", err)
+	}
+	return tlsConn, nil
+}
+
+// dialUpstreamTunnel mở tunnel tới hostPort qua một proxy HTTP upstream, tự động thử lại
+// với proxy khác khi thất bại theo cùng cách chọn proxy của handleHTTPSProxy
+func dialUpstreamTunnel(pm *ProxyManager, hostPort string) (net.Conn, *Proxy, error) {
+	pm = pm.ForDestination(hostPort)
+
+	// Theo dõi các proxy đã thử để tránh dùng lại chúng khi thử lại
+	triedProxies := make(map[string]bool)
+	var lastError error
+	var lastProxy *Proxy
+
+	for retry := 0; retry <= pm.maxRetries; retry++ {
+		var proxy *Proxy
+		if retry == 0 {
+			proxy = pm.GetRandomProxyWithFilter(httpOnlySelector)
+		} else {
+			var excludeURL string
+			if lastProxy != nil {
+				excludeURL = lastProxy.URL
+			}
+			proxy = pm.GetNextWorkingProxyWithFilter(excludeURL, httpOnlySelector)
+			if proxy != nil {
+				logger.Info("Tunnel retry %d/%d with proxy %s", retry, pm.maxRetries, proxy.URL)
+			}
+		}
+
+		if proxy == nil {
+			logger.Error("No more available HTTP proxies to try after %d attempts", retry)
+			break
+		}
+
+		// Bỏ qua nếu đã thử proxy này
+		if triedProxies[proxy.URL] {
+			proxy.cancelReservation()
+			continue
+		}
+		triedProxies[proxy.URL] = true
+		lastProxy = proxy
+
+		conn, err := dialViaHTTPProxy(proxy, hostPort)
+		if err != nil {
+			logger.Error("Tunnel via %s failed: %v", proxy.URL, err)
+			lastError = err
+			proxy.cancelReservation()
+			pm.MarkProxyFailed(proxy)
+			continue
+		}
+
+		pm.MarkProxySuccess(proxy)
+		return proxy.trackConn(conn), proxy, nil
+	}
+
+	if lastError == nil {
+		lastError = fmt.Errorf("no proxy available")
+	}
+	return nil, nil, lastError
+}
+
+// dialViaHTTPProxy kết nối tới proxy HTTP và gửi CONNECT tới hostPort
+func dialViaHTTPProxy(proxy *Proxy, hostPort string) (net.Conn, error) {
+	// Kết nối tới proxy với timeout
+	start := time.Now()
+	proxyConn, err := dialProxy(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to proxy: %v", err)
+	}
+	proxy.recordConnect(time.Since(start))
+
+	start = time.Now()
+	if err := sendConnect(proxyConn, proxy, hostPort); err != nil {
+		proxyConn.Close()
+		return nil, err
+	}
+	proxy.recordTTFB(time.Since(start))
+
+	return proxyConn, nil
+}
+
+// sendConnect gửi request CONNECT và đọc phản hồi từ proxy
+func sendConnect(proxyConn net.Conn, proxy *Proxy, hostPort string) error {
+	// Xây dựng request CONNECT
+	connectRequest := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n", hostPort, hostPort)
+
+	// Thêm xác thực proxy nếu cần
+	if username, password := proxy.credentials(); username != "" && password != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
+		connectRequest += fmt.Sprintf("Proxy-Authorization: Basic %s\r\n", auth)
+	}
+	connectRequest += "\r\n"
+
+	if err := proxyConn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		return fmt.Errorf("failed to set write deadline: %v", err)
+	}
+	if _, err := proxyConn.Write([]byte(connectRequest)); err != nil {
+		return fmt.Errorf("failed to send CONNECT request: %v", err)
+	}
+	if err := proxyConn.SetWriteDeadline(time.Time{}); err != nil {
+		logger.Error("Failed to reset write deadline: %v", err)
+	}
+
+	if err := proxyConn.SetReadDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return fmt.Errorf("failed to set read deadline: %v", err)
+	}
+
+	responseLine, err := readLine(proxyConn)
+	if err != nil {
+		return fmt.Errorf("failed to read response line: %v", err)
+	}
+
+	// Kiểm tra xem phản hồi có phải là 200 OK không
+	if !strings.Contains(responseLine, "200") {
+		return fmt.Errorf("proxy returned: %s", strings.TrimSpace(responseLine))
+	}
+
+	// Đọc hết headers
+	for {
+		line, err := readLine(proxyConn)
+		if err != nil {
+			return fmt.Errorf("failed to read header: %v", err)
+		}
+		if strings.TrimSpace(line) == "" {
+			break
+		}
+	}
+
+	if err := proxyConn.SetReadDeadline(time.Time{}); err != nil {
+		logger.Error("Failed to reset read deadline: %v", err)
+	}
+
+	return nil
+}
+
+// readLine đọc từng byte tới hết dòng để không nuốt mất dữ liệu tunnel phía sau header
+func readLine(conn net.Conn) (string, error) {
+	var line strings.Builder
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return line.String(), err
+		}
+		line.WriteByte(b[0])
+		if b[0] == '\n' {
+			return line.String(), nil
+		}
+	}
+}
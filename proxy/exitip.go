@@ -0,0 +1,221 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// AnonymityLevel là mức ẩn danh của proxy theo những gì đích nhìn thấy
+type AnonymityLevel string
+
+const (
+	AnonymityUnknown AnonymityLevel = ""
+	// AnonymityTransparent đích thấy IP thật của máy chạy proxy server
+	AnonymityTransparent AnonymityLevel = "transparent"
+	// AnonymityAnonymous IP thật bị che nhưng proxy để lộ header như Via, X-Forwarded-For
+	AnonymityAnonymous AnonymityLevel = "anonymous"
+	// AnonymityElite đích không thấy dấu hiệu nào của proxy
+	AnonymityElite AnonymityLevel = "elite"
+)
+
+// ExitIPCheckConfig cấu hình kiểm tra IP đầu ra trong health check.
+// URL là một "judge" phản hồi lại request nhận được: JSON kiểu httpbin.org/get
+// ({"origin": ..., "headers": {...}}) hoặc văn bản chứa IP và header (kiểu azenv.php).
+// Nên dùng URL http:// để proxy có cơ hội chèn header và bị phát hiện.
+type ExitIPCheckConfig struct {
+	URL string `json:"url"`
+}
+
+// revealingHeaders các header cho thấy request đi qua proxy
+var revealingHeaders = []string{
+	"via", "x-forwarded-for", "forwarded", "x-real-ip", "client-ip",
+	"x-client-ip", "x-originating-ip", "x-proxy-id", "proxy-connection",
+}
+
+// judgeResponse là thông tin trích từ phản hồi của judge
+type judgeResponse struct {
+	exitIP    string
+	ips       []string
+	revealing []string
+}
+
+// fetchJudge gửi request tới judge và trích IP đầu ra, mọi IP xuất hiện và các header lộ proxy
+func fetchJudge(client *http.Client, judgeURL string) (*judgeResponse, error) {
+	resp, err := client.Get(judgeURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("judge returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read judge response: %v", err)
+	}
+
+	result := parseJudgeJSON(body)
+	if result == nil {
+		result = parseJudgeText(string(body))
+	}
+	if result.exitIP == "" {
+		return nil, fmt.Errorf("judge response contains no IP address")
+	}
+	return result, nil
+}
+
+// parseJudgeJSON đọc phản hồi kiểu httpbin, origin có dạng "client, exit" khi proxy thêm X-Forwarded-For
+func parseJudgeJSON(body []byte) *judgeResponse {
+	var data struct {
+		Origin  string            `json:"origin"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil || data.Origin == "" {
+		return nil
+	}
+
+	result := &judgeResponse{}
+	for _, ip := range strings.Split(data.Origin, ",") {
+		if ip = strings.TrimSpace(ip); net.ParseIP(ip) != nil {
+			result.ips = append(result.ips, ip)
+			result.exitIP = ip
+		}
+	}
+
+	for name, value := range data.Headers {
+		for _, header := range revealingHeaders {
+			if strings.EqualFold(name, header) {
+				result.revealing = append(result.revealing, header)
+			}
+		}
+		result.ips = append(result.ips, extractIPs(value)...)
+	}
+	return result
+}
+
+// parseJudgeText đọc phản hồi dạng văn bản, IP đầu tiên được coi là IP đầu ra
+func parseJudgeText(body string) *judgeResponse {
+	result := &judgeResponse{ips: extractIPs(body)}
+	if len(result.ips) > 0 {
+		result.exitIP = result.ips[0]
+	}
+
+	lower := strings.ToLower(body)
+	for _, header := range revealingHeaders {
+		cgiName := "http_" + strings.ReplaceAll(header, "-", "_")
+		if strings.Contains(lower, header+":") || strings.Contains(lower, cgiName) {
+			result.revealing = append(result.revealing, header)
+		}
+	}
+	return result
+}
+
+// extractIPs tìm mọi địa chỉ IPv4/IPv6 hợp lệ trong chuỗi
+func extractIPs(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return !(r == '.' || r == ':' || (r >= '0' && r <= '9') || (r >= 'a' && r <= 'f') || (r >= 'A' && r <= 'F'))
+	})
+
+	var ips []string
+	for _, field := range fields {
+		field = strings.TrimRight(field, ".:")
+		if host, _, err := net.SplitHostPort(field); err == nil && net.ParseIP(host) != nil {
+			field = host
+		}
+		if ip := net.ParseIP(field); ip != nil && strings.ContainsAny(field, ".:") {
+			ips = append(ips, ip.String())
+		}
+	}
+	return ips
+}
+
+// classifyAnonymity xếp loại proxy dựa trên IP thật của máy chạy server
+func classifyAnonymity(result *judgeResponse, realIPs map[string]bool) AnonymityLevel {
+	for _, ip := range result.ips {
+		if realIPs[ip] {
+			return AnonymityTransparent
+		}
+	}
+	if len(result.revealing) > 0 {
+		return AnonymityAnonymous
+	}
+	return AnonymityElite
+}
+
+// lookupRealIPs hỏi judge trực tiếp (không qua proxy) để biết IP thật của máy chạy server
+func lookupRealIPs(judgeURL string, timeout time.Duration) map[string]bool {
+	client := &http.Client{
+		Transport: &http.Transport{Proxy: nil, DisableKeepAlives: true},
+		Timeout:   timeout,
+	}
+
+	realIPs := make(map[string]bool)
+	result, err := fetchJudge(client, judgeURL)
+	if err != nil {
+		logger.Error("Failed to look up real IP via %s: %v", judgeURL, err)
+		return realIPs
+	}
+
+	for _, ip := range result.ips {
+		realIPs[ip] = true
+	}
+	return realIPs
+}
+
+// checkExitIP ghi nhận IP đầu ra và mức ẩn danh của proxy qua judge
+func (hc *HealthChecker) checkExitIP(proxy *Proxy, client *http.Client, realIPs map[string]bool) {
+	result, err := fetchJudge(client, hc.exitIP.URL)
+	if err != nil {
+		logger.Info("Exit IP check failed for %s: %v", proxy.URL, err)
+		return
+	}
+
+	anonymity := classifyAnonymity(result, realIPs)
+
+	hc.pm.mu.Lock()
+	proxy.ExitIP = result.exitIP
+	proxy.Anonymity = anonymity
+	enrichGeo(proxy)
+	hc.pm.mu.Unlock()
+
+	if anonymity == AnonymityTransparent {
+		logger.Warn("Proxy %s is transparent: destination sees the real client IP", proxy.URL)
+	}
+	logger.Debug("Proxy %s exits via %s (%s)", proxy.URL, result.exitIP, anonymity)
+}
+
+// sharedExitIPs nhóm URL proxy theo IP đầu ra, chỉ giữ các IP dùng chung bởi nhiều proxy.
+// Gọi khi đang giữ pm.mu.
+func (pm *ProxyManager) sharedExitIPs() map[string][]string {
+	groups := make(map[string][]string)
+	for _, proxy := range pm.proxies {
+		if proxy.ExitIP != "" && proxy.State != BreakerRetired {
+			groups[proxy.ExitIP] = append(groups[proxy.ExitIP], proxy.URL)
+		}
+	}
+
+	for ip, urls := range groups {
+		if len(urls) < 2 {
+			delete(groups, ip)
+		}
+	}
+	return groups
+}
+
+// reportSharedExitIPs ghi log các IP đầu ra bị nhiều proxy dùng chung
+func (pm *ProxyManager) reportSharedExitIPs() {
+	pm.mu.RLock()
+	groups := pm.sharedExitIPs()
+	pm.mu.RUnlock()
+
+	for ip, urls := range groups {
+		logger.Warn("Exit IP %s is shared by %d proxies: %s", ip, len(urls), strings.Join(urls, ", "))
+	}
+}
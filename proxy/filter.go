@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Header cho phép client chọn proxy theo vị trí cho từng request, bị xóa trước khi chuyển tiếp
+const (
+	headerProxyCountry = "X-Proxy-Country"
+	headerProxyCity    = "X-Proxy-City"
+	headerProxyASN     = "X-Proxy-ASN"
+	headerProxyTag     = "X-Proxy-Tag"
+)
+
+// ProxyFilter giới hạn các proxy được chọn theo thông tin GeoIP và inventory. Mỗi danh sách
+// Countries, Cities, ASNs, Providers khác rỗng yêu cầu proxy khớp một giá trị trong đó; proxy chưa có
+// thông tin tương ứng không khớp. Tags yêu cầu proxy có đủ mọi nhãn.
+type ProxyFilter struct {
+	Countries []string `json:"countries,omitempty"`
+	Cities    []string `json:"cities,omitempty"`
+	ASNs      []uint   `json:"asns,omitempty"`
+
+	Tags      []string `json:"tags,omitempty"`
+	Providers []string `json:"providers,omitempty"`
+	// MaxCostPerGB chỉ chọn proxy có chi phí mỗi GB không vượt quá giá trị này, 0 là không lọc
+	MaxCostPerGB float64 `json:"max_cost_per_gb,omitempty"`
+}
+
+func (f *ProxyFilter) isEmpty() bool {
+	return f == nil || (len(f.Countries) == 0 && len(f.Cities) == 0 && len(f.ASNs) == 0 &&
+		len(f.Tags) == 0 && len(f.Providers) == 0 && f.MaxCostPerGB <= 0)
+}
+
+// matches kiểm tra proxy có thỏa bộ lọc. Gọi khi đang giữ pm.mu.
+func (f *ProxyFilter) matches(proxy *Proxy) bool {
+	if f.isEmpty() {
+		return true
+	}
+	if len(f.Countries) > 0 && !containsFold(f.Countries, proxy.Country) {
+		return false
+	}
+	if len(f.Cities) > 0 && !containsFold(f.Cities, proxy.City) {
+		return false
+	}
+	if len(f.ASNs) > 0 {
+		found := false
+		for _, asn := range f.ASNs {
+			if asn == proxy.ASN {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(f.Providers) > 0 && !containsFold(f.Providers, proxy.Provider) {
+		return false
+	}
+	if f.MaxCostPerGB > 0 && proxy.CostPerGB > f.MaxCostPerGB {
+		return false
+	}
+	return proxy.hasTags(f.Tags)
+}
+
+func containsFold(values []string, s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// merge trả về bộ lọc mới, các trường được đặt trong other ghi đè trường tương ứng của f
+func (f *ProxyFilter) merge(other *ProxyFilter) *ProxyFilter {
+	if other.isEmpty() {
+		return f
+	}
+	if f.isEmpty() {
+		return other
+	}
+
+	merged := *f
+	if len(other.Countries) > 0 {
+		merged.Countries = other.Countries
+	}
+	if len(other.Cities) > 0 {
+		merged.Cities = other.Cities
+	}
+	if len(other.ASNs) > 0 {
+		merged.ASNs = other.ASNs
+	}
+	if len(other.Tags) > 0 {
+		merged.Tags = other.Tags
+	}
+	if len(other.Providers) > 0 {
+		merged.Providers = other.Providers
+	}
+	if other.MaxCostPerGB > 0 {
+		merged.MaxCostPerGB = other.MaxCostPerGB
+	}
+	return &merged
+}
+
+// WithFilter trả về ProxyManager dùng chung pool và strategy nhưng chỉ chọn proxy thỏa
+// bộ lọc; bộ lọc mới được gộp lên bộ lọc hiện có của manager
+func (pm *ProxyManager) WithFilter(filter *ProxyFilter) *ProxyManager {
+	if filter.isEmpty() {
+		return pm
+	}
+	view := *pm
+	view.filter = pm.filter.merge(filter)
+	return &view
+}
+
+// filterFromHeaders đọc bộ lọc theo request từ các header X-Proxy-*, giá trị cách nhau bởi dấu phẩy
+func filterFromHeaders(get func(name string) string) *ProxyFilter {
+	filter := &ProxyFilter{
+		Countries: splitList(get(headerProxyCountry)),
+		Cities:    splitList(get(headerProxyCity)),
+		Tags:      splitList(get(headerProxyTag)),
+	}
+	for _, value := range splitList(get(headerProxyASN)) {
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+		if err == nil {
+			filter.ASNs = append(filter.ASNs, uint(asn))
+		}
+	}
+
+	if filter.isEmpty() {
+		return nil
+	}
+	return filter
+}
+
+func splitList(value string) []string {
+	var values []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// requestFilterFromMap đọc bộ lọc theo request từ header dạng map và xóa các header đó
+func requestFilterFromMap(headers map[string]string) *ProxyFilter {
+	get := func(name string) string {
+		for key, value := range headers {
+			if strings.EqualFold(key, name) {
+				return value
+			}
+		}
+		return ""
+	}
+	filter := filterFromHeaders(get)
+
+	for key := range headers {
+		for _, name := range []string{headerProxyCountry, headerProxyCity, headerProxyASN, headerProxyTag} {
+			if strings.EqualFold(key, name) {
+				delete(headers, key)
+			}
+		}
+	}
+	return filter
+}
+
+// requestFilterFromHeader đọc bộ lọc theo request từ http.Header và xóa các header đó
+func requestFilterFromHeader(header http.Header) *ProxyFilter {
+	filter := filterFromHeaders(header.Get)
+	header.Del(headerProxyCountry)
+	header.Del(headerProxyCity)
+	header.Del(headerProxyASN)
+	header.Del(headerProxyTag)
+	return filter
+}
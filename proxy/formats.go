@@ -0,0 +1,357 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Các định dạng danh sách proxy được hỗ trợ
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatCSV  = "csv"
+)
+
+// proxyRecord là một proxy trong danh sách dạng JSON, YAML hoặc CSV. Có thể khai báo url (định dạng
+// như một dòng của file text) hoặc host và port riêng, kèm metadata của inventory.
+type proxyRecord struct {
+	URL      string `json:"url" yaml:"url"`
+	Host     string `json:"host" yaml:"host"`
+	Port     string `json:"port" yaml:"port"`
+	Username string `json:"username" yaml:"username"`
+	Password string `json:"password" yaml:"password"`
+	Type     string `json:"type" yaml:"type"`
+
+	Tags      []string `json:"tags" yaml:"tags"`
+	Weight    int      `json:"weight" yaml:"weight"`
+	Country   string   `json:"country" yaml:"country"`
+	Provider  string   `json:"provider" yaml:"provider"`
+	MaxConns  int      `json:"max_conns" yaml:"max_conns"`
+	CostPerGB float64  `json:"cost_per_gb" yaml:"cost_per_gb"`
+	Expires   string   `json:"expires" yaml:"expires"` // Ngày (2006-01-02) hoặc thời điểm RFC 3339
+	Notes     string   `json:"notes" yaml:"notes"`
+	ID        string   `json:"id" yaml:"id"` // Mã proxy phía nhà cung cấp
+}
+
+// UnmarshalJSON chấp nhận cả chuỗi (một dòng proxy) lẫn object, port và id có thể là số hoặc chuỗi
+func (r *proxyRecord) UnmarshalJSON(data []byte) error {
+	var line string
+	if err := json.Unmarshal(data, &line); err == nil {
+		*r = proxyRecord{URL: line}
+		return nil
+	}
+
+	type plain proxyRecord
+	var aux struct {
+		plain
+		Port json.RawMessage `json:"port"`
+		ID   json.RawMessage `json:"id"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*r = proxyRecord(aux.plain)
+	r.Port = strings.Trim(string(aux.Port), `"`)
+	r.ID = strings.Trim(string(aux.ID), `"`)
+	return nil
+}
+
+// UnmarshalYAML chấp nhận cả chuỗi (một dòng proxy) lẫn mapping
+func (r *proxyRecord) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*r = proxyRecord{URL: node.Value}
+		return nil
+	}
+	type plain proxyRecord
+	return node.Decode((*plain)(r))
+}
+
+// toProxy chuyển record thành Proxy, proxy không có scheme hay type được gán defaultType
+func (r proxyRecord) toProxy(defaultType ProxyType) (*Proxy, error) {
+	line := r.URL
+	if line == "" {
+		if r.Host == "" || r.Port == "" {
+			return nil, fmt.Errorf("missing url or host and port")
+		}
+		line = net.JoinHostPort(strings.Trim(r.Host, "[]"), r.Port)
+	}
+	if r.Type != "" && !strings.Contains(line, "://") {
+		if _, ok := proxySchemes[strings.ToLower(r.Type)]; !ok {
+			return nil, fmt.Errorf("unsupported type %q", r.Type)
+		}
+		line = strings.ToLower(r.Type) + "://" + line
+	}
+	if r.Weight < 0 || r.MaxConns < 0 || r.CostPerGB < 0 {
+		return nil, fmt.Errorf("weight, max_conns and cost_per_gb must not be negative")
+	}
+
+	proxy, err := ParseProxy(line)
+	if err != nil {
+		return nil, err
+	}
+	if r.Username != "" {
+		proxy.Username = r.Username
+		proxy.Password = r.Password
+		if err := proxy.resolveCredentials(); err != nil {
+			return nil, err
+		}
+	}
+	proxy.applyDefaultType(defaultType)
+
+	proxy.Weight = r.Weight
+	proxy.Country = strings.ToUpper(r.Country)
+	proxy.declaredCountry = proxy.Country
+	proxy.ProxyMetadata = ProxyMetadata{
+		Tags:       r.Tags,
+		Provider:   r.Provider,
+		MaxConns:   r.MaxConns,
+		CostPerGB:  r.CostPerGB,
+		Notes:      r.Notes,
+		ProviderID: r.ID,
+	}
+	if r.Expires != "" {
+		if proxy.ExpiresAt, err = parseExpiry(r.Expires); err != nil {
+			return nil, err
+		}
+	}
+	return proxy, nil
+}
+
+// parseExpiry đọc thời điểm hết hạn dạng RFC 3339 hoặc ngày (hết hạn từ 00:00 UTC ngày đó)
+func parseExpiry(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expires %q, expected YYYY-MM-DD or RFC 3339", value)
+	}
+	return t, nil
+}
+
+// ParseProxyData phân tích danh sách proxy theo định dạng text, json, yaml hoặc csv (mặc định text).
+// Lỗi của từng proxy được trả về theo số dòng (text, yaml, csv) hoặc vị trí phần tử (json, tính từ 1).
+func ParseProxyData(data []byte, format string, defaultType ProxyType) ([]*Proxy, []*LineError, error) {
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		return ParseProxyList(bytes.NewReader(data), defaultType)
+	case FormatJSON:
+		return parseProxyJSON(data, defaultType)
+	case FormatYAML, "yml":
+		return parseProxyYAML(data, defaultType)
+	case FormatCSV:
+		return parseProxyCSV(data, defaultType)
+	default:
+		return nil, nil, fmt.Errorf("unsupported proxy list format %q", format)
+	}
+}
+
+// parseProxyJSON đọc mảng proxy, hoặc object có mảng trong trường "proxies" hay "data"
+func parseProxyJSON(data []byte, defaultType ProxyType) ([]*Proxy, []*LineError, error) {
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		var wrapped struct {
+			Proxies []json.RawMessage `json:"proxies"`
+			Data    []json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, nil, fmt.Errorf("invalid JSON proxy list: %v", err)
+		}
+		items = wrapped.Proxies
+		if items == nil {
+			items = wrapped.Data
+		}
+	}
+
+	var proxies []*Proxy
+	var lineErrors []*LineError
+	for i, item := range items {
+		var record proxyRecord
+		if err := json.Unmarshal(item, &record); err != nil {
+			lineErrors = append(lineErrors, &LineError{Line: i + 1, Err: fmt.Errorf("invalid entry: %v", err)})
+			continue
+		}
+		proxy, err := record.toProxy(defaultType)
+		if err != nil {
+			lineErrors = append(lineErrors, &LineError{Line: i + 1, Err: err})
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, lineErrors, nil
+}
+
+// parseProxyYAML đọc danh sách proxy, hoặc mapping có danh sách trong khóa "proxies"
+func parseProxyYAML(data []byte, defaultType ProxyType) ([]*Proxy, []*LineError, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, fmt.Errorf("invalid YAML proxy list: %v", err)
+	}
+	if len(doc.Content) == 0 {
+		return nil, nil, nil
+	}
+
+	list := doc.Content[0]
+	if list.Kind == yaml.MappingNode {
+		var items *yaml.Node
+		for i := 0; i+1 < len(list.Content); i += 2 {
+			if list.Content[i].Value == "proxies" {
+				items = list.Content[i+1]
+			}
+		}
+		if items == nil {
+			return nil, nil, fmt.Errorf("YAML proxy list has no \"proxies\" key")
+		}
+		list = items
+	}
+	if list.Kind != yaml.SequenceNode {
+		return nil, nil, fmt.Errorf("YAML proxy list must be a sequence")
+	}
+
+	var proxies []*Proxy
+	var lineErrors []*LineError
+	for _, item := range list.Content {
+		var record proxyRecord
+		if err := item.Decode(&record); err != nil {
+			lineErrors = append(lineErrors, &LineError{Line: item.Line, Err: fmt.Errorf("invalid entry: %v", err)})
+			continue
+		}
+		proxy, err := record.toProxy(defaultType)
+		if err != nil {
+			lineErrors = append(lineErrors, &LineError{Line: item.Line, Err: err})
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, lineErrors, nil
+}
+
+// csvColumns ánh xạ tên cột CSV (không phân biệt hoa thường) sang cách gán vào proxyRecord
+var csvColumns = map[string]func(r *proxyRecord, value string) error{
+	"url":         func(r *proxyRecord, v string) error { r.URL = v; return nil },
+	"proxy":       func(r *proxyRecord, v string) error { r.URL = v; return nil },
+	"host":        func(r *proxyRecord, v string) error { r.Host = v; return nil },
+	"ip":          func(r *proxyRecord, v string) error { r.Host = v; return nil },
+	"port":        func(r *proxyRecord, v string) error { r.Port = v; return nil },
+	"username":    func(r *proxyRecord, v string) error { r.Username = v; return nil },
+	"user":        func(r *proxyRecord, v string) error { r.Username = v; return nil },
+	"password":    func(r *proxyRecord, v string) error { r.Password = v; return nil },
+	"pass":        func(r *proxyRecord, v string) error { r.Password = v; return nil },
+	"type":        func(r *proxyRecord, v string) error { r.Type = v; return nil },
+	"protocol":    func(r *proxyRecord, v string) error { r.Type = v; return nil },
+	"tags":        func(r *proxyRecord, v string) error { r.Tags = splitTags(v); return nil },
+	"weight":      func(r *proxyRecord, v string) (err error) { r.Weight, err = csvInt(v); return },
+	"country":     func(r *proxyRecord, v string) error { r.Country = v; return nil },
+	"provider":    func(r *proxyRecord, v string) error { r.Provider = v; return nil },
+	"max_conns":   func(r *proxyRecord, v string) (err error) { r.MaxConns, err = csvInt(v); return },
+	"cost_per_gb": func(r *proxyRecord, v string) (err error) { r.CostPerGB, err = csvFloat(v); return },
+	"expires":     func(r *proxyRecord, v string) error { r.Expires = v; return nil },
+	"notes":       func(r *proxyRecord, v string) error { r.Notes = v; return nil },
+	"id":          func(r *proxyRecord, v string) error { r.ID = v; return nil },
+}
+
+// splitTags tách danh sách nhãn trong một ô CSV, cách nhau bởi ';' hoặc '|'
+func splitTags(value string) []string {
+	var tags []string
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ';' || r == '|' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// csvInt đọc số nguyên từ ô CSV, ô trống là 0
+func csvInt(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return n, nil
+}
+
+// csvFloat đọc số thực từ ô CSV, ô trống là 0
+func csvFloat(value string) (float64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", value)
+	}
+	return f, nil
+}
+
+// parseProxyCSV đọc CSV có dòng tiêu đề, các cột không nhận ra được bỏ qua
+func parseProxyCSV(data []byte, defaultType ProxyType) ([]*Proxy, []*LineError, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	header, err := reader.Read()
+	if err != nil {
+		if err == io.EOF {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("invalid CSV header: %v", err)
+	}
+	columns := make([]func(r *proxyRecord, value string) error, len(header))
+	known := false
+	for i, name := range header {
+		columns[i] = csvColumns[strings.ToLower(strings.TrimSpace(name))]
+		known = known || columns[i] != nil
+	}
+	if !known {
+		return nil, nil, fmt.Errorf("CSV header has no url or host/port column")
+	}
+
+	var proxies []*Proxy
+	var lineErrors []*LineError
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var line int
+			if parseErr, ok := err.(*csv.ParseError); ok {
+				line = parseErr.Line
+			}
+			lineErrors = append(lineErrors, &LineError{Line: line, Err: err})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		var record proxyRecord
+		for i, value := range row {
+			if i < len(columns) && columns[i] != nil && err == nil {
+				err = columns[i](&record, strings.TrimSpace(value))
+			}
+		}
+		if err != nil {
+			lineErrors = append(lineErrors, &LineError{Line: line, Err: err})
+			continue
+		}
+		proxy, err := record.toProxy(defaultType)
+		if err != nil {
+			lineErrors = append(lineErrors, &LineError{Line: line, Err: err})
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+	return proxies, lineErrors, nil
+}
@@ -0,0 +1,176 @@
+package proxy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// GatewayRoute ánh xạ request tới một origin theo host và/hoặc tiền tố đường dẫn
+type GatewayRoute struct {
+	Host        string `json:"host,omitempty"`
+	PathPrefix  string `json:"path_prefix,omitempty"`
+	Origin      string `json:"origin"`
+	StripPrefix bool   `json:"strip_prefix,omitempty"`
+}
+
+// Các header hop-by-hop không được chuyển tiếp qua gateway
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// gatewayHandler là reverse proxy chuyển tiếp request tới origin qua pool proxy
+type gatewayHandler struct {
+	routes  []GatewayRoute
+	origins []*url.URL
+	pm      *ProxyManager
+}
+
+// StartGatewayServer khởi động listener reverse proxy cho các origin được cấu hình
+func StartGatewayServer(pm *ProxyManager, lc ListenerConfig) error {
+	handler, err := newGatewayHandler(pm, lc.Routes)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Starting gateway server on %s with %d routes", lc.Addr, len(lc.Routes))
+	return http.ListenAndServe(lc.Addr, handler)
+}
+
+func newGatewayHandler(pm *ProxyManager, routes []GatewayRoute) (*gatewayHandler, error) {
+	h := &gatewayHandler{
+		routes: routes,
+		pm:     pm,
+	}
+
+	for _, route := range routes {
+		origin, err := url.Parse(route.Origin)
+		if err != nil || origin.Host == "" {
+			return nil, fmt.Errorf("invalid gateway origin %q", route.Origin)
+		}
+		h.origins = append(h.origins, origin)
+	}
+
+	return h, nil
+}
+
+// match trả về route đầu tiên khớp với request
+func (h *gatewayHandler) match(r *http.Request) (*GatewayRoute, *url.URL) {
+	host := r.Host
+	if hostOnly, _, err := net.SplitHostPort(host); err == nil {
+		host = hostOnly
+	}
+
+	for i := range h.routes {
+		route := &h.routes[i]
+		if route.Host != "" && !strings.EqualFold(route.Host, host) {
+			continue
+		}
+		if route.PathPrefix != "" && !strings.HasPrefix(r.URL.Path, route.PathPrefix) {
+			continue
+		}
+		return route, h.origins[i]
+	}
+
+	return nil, nil
+}
+
+func (h *gatewayHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	route, origin := h.match(r)
+	if route == nil {
+		http.Error(w, "no gateway route", http.StatusNotFound)
+		return
+	}
+
+	// Xây dựng URL đích từ origin
+	reqPath := r.URL.Path
+	if route.StripPrefix {
+		reqPath = "/" + strings.TrimPrefix(reqPath, route.PathPrefix)
+	}
+	target := *origin
+	target.Path = path.Join("/", origin.Path, reqPath)
+	if strings.HasSuffix(reqPath, "/") && !strings.HasSuffix(target.Path, "/") {
+		target.Path += "/"
+	}
+	target.RawPath = ""
+	target.RawQuery = r.URL.RawQuery
+
+	outReq := r.Clone(r.Context())
+	outReq.URL = &target
+	outReq.Host = origin.Host
+	outReq.RequestURI = ""
+	for _, header := range hopHeaders {
+		outReq.Header.Del(header)
+	}
+
+	if err := bufferRequestBody(outReq, r); err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	logger.Info("Gateway %s %s -> %s", r.Method, r.URL.String(), target.String())
+
+	resp, err := roundTripRouted(h.pm, outReq, r.RemoteAddr)
+	if err == errRouteRejected {
+		http.Error(w, "Blocked by routing rules", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		logger.Error("Gateway request to %s failed: %v", target.String(), err)
+		http.Error(w, fmt.Sprintf("All proxy attempts failed: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	writeResponse(w, resp)
+}
+
+// bufferRequestBody đọc trước body để có thể gửi lại khi thử lại với proxy khác
+func bufferRequestBody(outReq, r *http.Request) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		outReq.Body = http.NoBody
+		return nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	outReq.Body = io.NopCloser(bytes.NewReader(body))
+	outReq.ContentLength = int64(len(body))
+	outReq.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+// writeResponse chép phản hồi từ upstream về client, bỏ các header hop-by-hop
+func writeResponse(w http.ResponseWriter, resp *http.Response) {
+	defer resp.Body.Close()
+
+	for key, values := range resp.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	for _, header := range hopHeaders {
+		w.Header().Del(header)
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		logger.Error("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// GeoIPConfig cấu hình các file cơ sở dữ liệu định dạng MaxMind (.mmdb) để tra vị trí và nhà mạng
+type GeoIPConfig struct {
+	// CityDB file GeoIP2/GeoLite2-City hoặc -Country
+	CityDB string `json:"city_db,omitempty"`
+	// ASNDB file GeoLite2-ASN
+	ASNDB string `json:"asn_db,omitempty"`
+	// ISPDB file GeoIP2-ISP, ưu tiên hơn ASNDB khi có
+	ISPDB string `json:"isp_db,omitempty"`
+}
+
+// geoIPDB giữ các reader đã mở, chỉ đọc nên dùng được từ nhiều goroutine
+type geoIPDB struct {
+	cfg      GeoIPConfig
+	modTimes [3]time.Time // Thời điểm sửa các file lúc mở, theo thứ tự city, asn, isp
+
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+	isp  *maxminddb.Reader
+}
+
+// geoRecord là thông tin vị trí và nhà mạng của một IP
+type geoRecord struct {
+	Country string
+	City    string
+	Region  string
+	ISP     string
+	ASN     uint
+}
+
+// geoIP là cơ sở dữ liệu đang dùng, nil khi tắt. geoIPMu được giữ (khóa đọc) trong lúc tra để reader
+// không bị đóng giữa chừng khi cơ sở dữ liệu được thay.
+var (
+	geoIPMu sync.RWMutex
+	geoIP   *geoIPDB
+)
+
+// ConfigureGeoIP mở các file cơ sở dữ liệu GeoIP, nil để tắt. Cơ sở dữ liệu cũ được đóng khi bị thay;
+// cấu hình và các file không đổi thì giữ nguyên reader đang mở.
+func ConfigureGeoIP(cfg *GeoIPConfig) error {
+	db, err := openGeoIP(cfg)
+	if err != nil {
+		return err
+	}
+	replaceGeoIP(db)
+	return nil
+}
+
+// openGeoIP mở cơ sở dữ liệu theo cấu hình mà chưa dùng nó, trả về cơ sở dữ liệu hiện tại nếu cấu hình
+// và các file không đổi, nil khi cfg nil
+func openGeoIP(cfg *GeoIPConfig) (*geoIPDB, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	db := &geoIPDB{cfg: *cfg}
+	files := []struct {
+		path string
+		dst  **maxminddb.Reader
+	}{
+		{cfg.CityDB, &db.city},
+		{cfg.ASNDB, &db.asn},
+		{cfg.ISPDB, &db.isp},
+	}
+	for i, f := range files {
+		if f.path == "" {
+			continue
+		}
+		if info, err := os.Stat(f.path); err == nil {
+			db.modTimes[i] = info.ModTime()
+		}
+	}
+
+	geoIPMu.RLock()
+	current := geoIP
+	geoIPMu.RUnlock()
+	if current != nil && current.cfg == db.cfg && current.modTimes == db.modTimes {
+		return current, nil
+	}
+
+	for _, f := range files {
+		if f.path == "" {
+			continue
+		}
+		reader, err := maxminddb.Open(f.path)
+		if err != nil {
+			db.close()
+			return nil, fmt.Errorf("failed to open GeoIP database %s: %v", f.path, err)
+		}
+		*f.dst = reader
+	}
+	return db, nil
+}
+
+// replaceGeoIP thay cơ sở dữ liệu đang dùng và đóng cơ sở dữ liệu cũ sau khi không còn lượt tra nào
+func replaceGeoIP(db *geoIPDB) {
+	geoIPMu.Lock()
+	old := geoIP
+	geoIP = db
+	geoIPMu.Unlock()
+
+	if old == db {
+		return
+	}
+	if old != nil {
+		old.close()
+	}
+	if db != nil {
+		logger.Info("GeoIP enrichment enabled")
+	}
+}
+
+// close đóng các reader đã mở
+func (db *geoIPDB) close() {
+	for _, reader := range []*maxminddb.Reader{db.city, db.asn, db.isp} {
+		if reader != nil {
+			reader.Close()
+		}
+	}
+}
+
+// lookup tra thông tin của IP trong các cơ sở dữ liệu đã cấu hình
+func (db *geoIPDB) lookup(ip net.IP) (*geoRecord, error) {
+	record := &geoRecord{}
+
+	if db.city != nil {
+		var city struct {
+			Country struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"country"`
+			City struct {
+				Names map[string]string `maxminddb:"names"`
+			} `maxminddb:"city"`
+			Subdivisions []struct {
+				ISOCode string `maxminddb:"iso_code"`
+			} `maxminddb:"subdivisions"`
+		}
+		if err := db.city.Lookup(ip, &city); err != nil {
+			return nil, err
+		}
+		record.Country = city.Country.ISOCode
+		record.City = city.City.Names["en"]
+		if len(city.Subdivisions) > 0 {
+			record.Region = city.Subdivisions[0].ISOCode
+		}
+	}
+
+	if db.isp != nil {
+		var isp struct {
+			ISP string `maxminddb:"isp"`
+			ASN uint   `maxminddb:"autonomous_system_number"`
+		}
+		if err := db.isp.Lookup(ip, &isp); err != nil {
+			return nil, err
+		}
+		record.ISP = isp.ISP
+		record.ASN = isp.ASN
+	} else if db.asn != nil {
+		var asn struct {
+			ASN          uint   `maxminddb:"autonomous_system_number"`
+			Organization string `maxminddb:"autonomous_system_organization"`
+		}
+		if err := db.asn.Lookup(ip, &asn); err != nil {
+			return nil, err
+		}
+		record.ISP = asn.Organization
+		record.ASN = asn.ASN
+	}
+
+	return record, nil
+}
+
+// geoLookupIP trả về IP dùng để tra vị trí: IP đầu ra nếu đã biết, nếu không là IP của proxy
+func geoLookupIP(proxy *Proxy) net.IP {
+	if proxy.ExitIP != "" {
+		return net.ParseIP(proxy.ExitIP)
+	}
+
+	host := proxy.URL
+	if u, err := url.Parse(proxy.URL); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	return net.ParseIP(host)
+}
+
+// enrichGeo gán thông tin vị trí và nhà mạng cho proxy. Gọi khi đang giữ pm.mu (khóa ghi).
+func enrichGeo(proxy *Proxy) {
+	geoIPMu.RLock()
+	defer geoIPMu.RUnlock()
+
+	if geoIP == nil {
+		return
+	}
+
+	ip := geoLookupIP(proxy)
+	if ip == nil {
+		return
+	}
+
+	record, err := geoIP.lookup(ip)
+	if err != nil {
+		logger.Error("GeoIP lookup for %s failed: %v", ip, err)
+		return
+	}
+
+	proxy.Country = record.Country
+	if proxy.declaredCountry != "" {
+		proxy.Country = proxy.declaredCountry
+	}
+	proxy.City = record.City
+	proxy.Region = record.Region
+	proxy.ISP = record.ISP
+	proxy.ASN = record.ASN
+}
+
+// EnrichGeo tra lại thông tin vị trí cho tất cả proxy trong pool
+func (pm *ProxyManager) EnrichGeo() {
+	geoIPMu.RLock()
+	enabled := geoIP != nil
+	geoIPMu.RUnlock()
+	if !enabled {
+		return
+	}
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for _, proxy := range pm.proxies {
+		enrichGeo(proxy)
+	}
+}
@@ -0,0 +1,348 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheckConfig cấu hình health check chủ động cho proxy trong pool
+type HealthCheckConfig struct {
+	Disabled bool `json:"disabled,omitempty"`
+
+	// Interval chu kỳ kiểm tra, mặc định theo SetCheckInterval (5m)
+	Interval string `json:"interval,omitempty"`
+	// Timeout thời gian tối đa cho mỗi lần thử một target, mặc định 10s
+	Timeout string `json:"timeout,omitempty"`
+	// Jitter độ trễ ngẫu nhiên tối đa trước mỗi lần kiểm tra một proxy, mặc định 1/10 Interval
+	Jitter string `json:"jitter,omitempty"`
+	// Concurrency số proxy được kiểm tra đồng thời, mặc định 10
+	Concurrency int `json:"concurrency,omitempty"`
+
+	// Targets các URL kiểm tra theo thứ tự, proxy đạt khi một target bất kỳ trả về
+	// phản hồi như mong đợi. Mặc định là URL của SetTestURL.
+	Targets []HealthCheckTarget `json:"targets,omitempty"`
+
+	// ExitIP bật kiểm tra IP đầu ra và phân loại mức ẩn danh sau khi proxy đạt
+	ExitIP *ExitIPCheckConfig `json:"exit_ip_check,omitempty"`
+}
+
+// HealthCheckTarget là một URL kiểm tra cùng phản hồi mong đợi
+type HealthCheckTarget struct {
+	URL string `json:"url"`
+
+	// ExpectedStatus các mã trạng thái hợp lệ, mặc định mọi mã 2xx
+	ExpectedStatus []int `json:"expected_status,omitempty"`
+	// ExpectedBody chuỗi phải xuất hiện trong body phản hồi nếu khác rỗng
+	ExpectedBody string `json:"expected_body,omitempty"`
+}
+
+// HealthChecker chạy health check định kỳ cho một pool cho tới khi Stop
+type HealthChecker struct {
+	pm          *ProxyManager
+	interval    time.Duration
+	timeout     time.Duration
+	jitter      time.Duration
+	concurrency int
+	targets     []HealthCheckTarget
+	exitIP      *ExitIPCheckConfig
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewHealthChecker tạo health checker cho pool từ cấu hình, nil để dùng mặc định
+func NewHealthChecker(pm *ProxyManager, cfg *HealthCheckConfig) (*HealthChecker, error) {
+	if cfg == nil {
+		cfg = &HealthCheckConfig{}
+	}
+
+	pm.mu.RLock()
+	hc := &HealthChecker{
+		pm:          pm,
+		interval:    pm.checkInterval,
+		timeout:     10 * time.Second,
+		concurrency: cfg.Concurrency,
+		targets:     cfg.Targets,
+		exitIP:      cfg.ExitIP,
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if len(hc.targets) == 0 {
+		hc.targets = []HealthCheckTarget{{URL: pm.testURL}}
+	}
+	pm.mu.RUnlock()
+
+	durations := []struct {
+		value string
+		dst   *time.Duration
+		name  string
+	}{
+		{cfg.Interval, &hc.interval, "interval"},
+		{cfg.Timeout, &hc.timeout, "timeout"},
+		{cfg.Jitter, &hc.jitter, "jitter"},
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		parsed, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health check %s: %v", d.name, err)
+		}
+		*d.dst = parsed
+	}
+
+	if hc.interval <= 0 {
+		return nil, fmt.Errorf("health check interval must be positive")
+	}
+	if cfg.Jitter == "" {
+		hc.jitter = hc.interval / 10
+	}
+	if hc.concurrency <= 0 {
+		hc.concurrency = 10
+	}
+	for _, target := range hc.targets {
+		if _, err := url.Parse(target.URL); err != nil || target.URL == "" {
+			return nil, fmt.Errorf("invalid health check target %q", target.URL)
+		}
+	}
+	if hc.exitIP != nil {
+		if _, err := url.Parse(hc.exitIP.URL); err != nil || hc.exitIP.URL == "" {
+			return nil, fmt.Errorf("invalid exit IP check URL %q", hc.exitIP.URL)
+		}
+	}
+
+	return hc, nil
+}
+
+// StartHealthChecker tạo và chạy health checker cho pool, trả về nil nếu bị tắt trong cấu hình
+func StartHealthChecker(pm *ProxyManager, cfg *HealthCheckConfig) (*HealthChecker, error) {
+	if cfg != nil && cfg.Disabled {
+		return nil, nil
+	}
+
+	hc, err := NewHealthChecker(pm, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	go hc.run()
+	return hc, nil
+}
+
+// Stop dừng health checker và chờ lượt kiểm tra đang chạy kết thúc
+func (hc *HealthChecker) Stop() {
+	hc.stopOnce.Do(func() { close(hc.stop) })
+	<-hc.done
+}
+
+func (hc *HealthChecker) run() {
+	defer close(hc.done)
+
+	logger.Info("Health checker started: interval %v, concurrency %d, %d targets", hc.interval, hc.concurrency, len(hc.targets))
+
+	ticker := time.NewTicker(hc.interval)
+	defer ticker.Stop()
+
+	for {
+		hc.CheckAll()
+
+		select {
+		case <-hc.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll kiểm tra tất cả proxy chưa retired với số lượng đồng thời giới hạn.
+// pm.mu chỉ được giữ khi lấy danh sách và khi cập nhật kết quả, không giữ trong lúc kết nối mạng.
+func (hc *HealthChecker) CheckAll() {
+	hc.pm.mu.RLock()
+	proxies := make([]*Proxy, 0, len(hc.pm.proxies))
+	for _, proxy := range hc.pm.proxies {
+		if proxy.State != BreakerRetired {
+			proxies = append(proxies, proxy)
+		}
+	}
+	hc.pm.mu.RUnlock()
+
+	var realIPs map[string]bool
+	if hc.exitIP != nil {
+		realIPs = lookupRealIPs(hc.exitIP.URL, hc.timeout)
+	}
+
+	sem := make(chan struct{}, hc.concurrency)
+	var wg sync.WaitGroup
+
+	for _, proxy := range proxies {
+		select {
+		case <-hc.stop:
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+
+		wg.Add(1)
+		go func(proxy *Proxy) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Rải đều các lần kiểm tra để không dồn tải lên target và upstream
+			if hc.jitter > 0 {
+				select {
+				case <-time.After(time.Duration(rand.Int63n(int64(hc.jitter)))):
+				case <-hc.stop:
+					return
+				}
+			}
+
+			hc.applyResult(proxy, hc.checkProxy(proxy, realIPs))
+		}(proxy)
+	}
+
+	wg.Wait()
+
+	if hc.exitIP != nil {
+		hc.pm.reportSharedExitIPs()
+	}
+}
+
+// applyResult cập nhật trạng thái proxy theo kết quả kiểm tra
+func (hc *HealthChecker) applyResult(proxy *Proxy, err error) {
+	proxy.recordResult(err == nil)
+
+	hc.pm.mu.Lock()
+	defer hc.pm.mu.Unlock()
+
+	proxy.LastChecked = time.Now()
+	if err != nil {
+		logger.Info("Proxy test failed for %s: %v", proxy.URL, err)
+		hc.pm.recordFailure(proxy)
+		return
+	}
+
+	logger.Debug("Proxy test successful for %s", proxy.URL)
+	hc.pm.recordSuccess(proxy)
+}
+
+// checkProxy thử lần lượt các target qua proxy, trả về nil khi một target đạt.
+// Khi proxy đạt và có cấu hình exit_ip_check, kiểm tra thêm IP đầu ra.
+func (hc *HealthChecker) checkProxy(proxy *Proxy, realIPs map[string]bool) error {
+	transport, err := checkTransport(proxy)
+	if err != nil {
+		return err
+	}
+	defer transport.CloseIdleConnections()
+
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   hc.timeout,
+	}
+
+	var lastErr error
+	for _, target := range hc.targets {
+		if lastErr = checkTarget(client, proxy, target); lastErr == nil {
+			break
+		}
+	}
+	if lastErr != nil {
+		return lastErr
+	}
+
+	if hc.exitIP != nil {
+		hc.checkExitIP(proxy, client, realIPs)
+	}
+	return nil
+}
+
+// checkTarget gửi request kiểm tra và so khớp với phản hồi mong đợi
+func checkTarget(client *http.Client, proxy *Proxy, target HealthCheckTarget) error {
+	req, err := http.NewRequest(http.MethodGet, target.URL, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), proxy.clientTrace(0)))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !expectedStatus(target.ExpectedStatus, resp.StatusCode) {
+		return fmt.Errorf("%s returned status %d", target.URL, resp.StatusCode)
+	}
+
+	if target.ExpectedBody != "" {
+		body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", target.URL, err)
+		}
+		if !strings.Contains(string(body), target.ExpectedBody) {
+			return fmt.Errorf("%s response does not contain %q", target.URL, target.ExpectedBody)
+		}
+	}
+
+	return nil
+}
+
+func expectedStatus(expected []int, code int) bool {
+	if len(expected) == 0 {
+		return code >= 200 && code < 300
+	}
+	for _, c := range expected {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransport tạo transport không giữ kết nối, đi qua proxy, dùng cho các request kiểm tra
+func checkTransport(proxy *Proxy) (*http.Transport, error) {
+	proxyURL, err := upstreamURL(proxy)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		Proxy:             http.ProxyURL(proxyURL),
+		DisableKeepAlives: true,
+	}
+	if proxy.Type == ProxyTypeSOCKS4 {
+		// http.Transport không hỗ trợ SOCKS4 nên tự mở kết nối qua proxy
+		transport.Proxy = nil
+		transport.DialContext = socks4DialContext(proxy)
+	}
+	return transport, nil
+}
+
+// upstreamURL dựng URL của proxy kèm thông tin đăng nhập, scheme theo loại proxy
+func upstreamURL(proxy *Proxy) (*url.URL, error) {
+	raw := proxy.URL
+	if !strings.Contains(raw, "://") {
+		if proxy.Type != ProxyTypeUnknown {
+			raw = string(proxy.Type) + "://" + raw
+		} else {
+			raw = "http://" + raw
+		}
+	}
+
+	proxyURL, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL %s: %v", proxy.URL, err)
+	}
+	if username, password := proxy.credentials(); username != "" && password != "" {
+		proxyURL.User = url.UserPassword(username, password)
+	}
+	return proxyURL, nil
+}
@@ -0,0 +1,269 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// Các header WebSocket được chuyển tiếp giữa extended CONNECT và HTTP/1.1 Upgrade
+var webSocketHeaders = []string{
+	"Origin",
+	"Sec-Websocket-Version",
+	"Sec-Websocket-Protocol",
+	"Sec-Websocket-Extensions",
+}
+
+// http2ProxyHandler xử lý các stream HTTP/2 trên listener TLS: CONNECT, extended CONNECT
+// (WebSocket) và request chuyển tiếp thông thường
+type http2ProxyHandler struct {
+	pm *ProxyManager
+}
+
+// serveHTTP2 phục vụ một kết nối TLS đã thỏa thuận ALPN "h2"
+func serveHTTP2(conn net.Conn, pm *ProxyManager) {
+	server := &http2.Server{}
+	server.ServeConn(conn, &http2.ServeConnOpts{
+		Handler: &http2ProxyHandler{pm: pm},
+	})
+}
+
+func (h *http2ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		switch protocol := r.Header.Get(":protocol"); protocol {
+		case "":
+			h.serveConnect(w, r)
+		case "websocket":
+			h.serveWebSocket(w, r)
+		default:
+			http.Error(w, fmt.Sprintf("unsupported protocol %q", protocol), http.StatusNotImplemented)
+		}
+		return
+	}
+
+	h.serveForward(w, r)
+}
+
+// serveConnect mở tunnel qua proxy upstream cho một stream CONNECT
+func (h *http2ProxyHandler) serveConnect(w http.ResponseWriter, r *http.Request) {
+	logger.Info("Handling HTTP/2 CONNECT request to %s", r.Host)
+
+	proxyConn, via, ok := h.dialTunnel(w, r, r.Host)
+	if !ok {
+		return
+	}
+	defer proxyConn.Close()
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	logger.Info("HTTP/2 tunnel established %s to %s", via, r.Host)
+
+	pipeStream(w, r.Body, proxyConn, proxyConn)
+}
+
+// serveWebSocket chuyển extended CONNECT (RFC 8441) thành WebSocket Upgrade HTTP/1.1 qua proxy upstream
+func (h *http2ProxyHandler) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	scheme := requestScheme(r)
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host, port = r.Host, "80"
+		if scheme == "https" {
+			port = "443"
+		}
+	}
+	hostPort := net.JoinHostPort(host, port)
+	logger.Info("Handling HTTP/2 WebSocket request to %s%s", hostPort, r.URL.RequestURI())
+
+	proxyConn, via, ok := h.dialTunnel(w, r, hostPort)
+	if !ok {
+		return
+	}
+
+	var upstream net.Conn = proxyConn
+	if scheme == "https" {
+		tlsConn := tls.Client(proxyConn, &tls.Config{ServerName: host, NextProtos: []string{"http/1.1"}})
+		if err := tlsConn.HandshakeContext(r.Context()); err != nil {
+			proxyConn.Close()
+			logger.Error("TLS handshake with %s failed: %v", hostPort, err)
+			http.Error(w, "upstream TLS handshake failed", http.StatusBadGateway)
+			return
+		}
+		upstream = tlsConn
+	}
+	defer upstream.Close()
+
+	key := make([]byte, 16)
+	rand.Read(key)
+
+	upgradeReq, _ := http.NewRequest(http.MethodGet, "http://"+r.Host+r.URL.RequestURI(), nil)
+	upgradeReq.Host = r.Host
+	upgradeReq.Header.Set("Connection", "Upgrade")
+	upgradeReq.Header.Set("Upgrade", "websocket")
+	upgradeReq.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	for _, header := range webSocketHeaders {
+		if value := r.Header.Get(header); value != "" {
+			upgradeReq.Header.Set(header, value)
+		}
+	}
+
+	if err := upgradeReq.Write(upstream); err != nil {
+		logger.Error("Failed to send WebSocket upgrade to %s: %v", hostPort, err)
+		http.Error(w, "failed to send upgrade request", http.StatusBadGateway)
+		return
+	}
+
+	reader := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(reader, upgradeReq)
+	if err != nil {
+		logger.Error("Failed to read WebSocket upgrade response from %s: %v", hostPort, err)
+		http.Error(w, "invalid upgrade response", http.StatusBadGateway)
+		return
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		writeResponse(w, resp)
+		return
+	}
+
+	for _, header := range []string{"Sec-Websocket-Protocol", "Sec-Websocket-Extensions"} {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	logger.Info("HTTP/2 WebSocket established %s to %s", via, hostPort)
+
+	pipeStream(w, r.Body, reader, upstream)
+}
+
+// serveForward chuyển tiếp request HTTP/2 thông thường qua pool proxy với cơ chế thử lại
+func (h *http2ProxyHandler) serveForward(w http.ResponseWriter, r *http.Request) {
+	outReq := r.Clone(r.Context())
+	outReq.URL.Scheme = requestScheme(r)
+	outReq.URL.Host = r.Host
+	outReq.RequestURI = ""
+	for _, header := range hopHeaders {
+		outReq.Header.Del(header)
+	}
+
+	if err := bufferRequestBody(outReq, r); err != nil {
+		http.Error(w, "failed to read request body", http.StatusBadRequest)
+		return
+	}
+
+	logger.Info("Handling HTTP/2 proxy request: %s %s", r.Method, outReq.URL.String())
+
+	resp, err := roundTripRouted(h.pm, outReq, r.RemoteAddr)
+	if err == errRouteRejected {
+		http.Error(w, "Blocked by routing rules", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		logger.Error("HTTP/2 request to %s failed: %v", outReq.URL.String(), err)
+		http.Error(w, fmt.Sprintf("All proxy attempts failed: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	writeResponse(w, resp)
+}
+
+// dialTunnel mở kết nối tới hostPort theo luật định tuyến: thẳng tới đích với direct, qua proxy upstream
+// với pool. Trả về mô tả đường đi cho log; khi thất bại đã trả lỗi cho client.
+func (h *http2ProxyHandler) dialTunnel(w http.ResponseWriter, r *http.Request, hostPort string) (net.Conn, string, bool) {
+	action, routed := route(h.pm, hostPort, r.RemoteAddr)
+	switch action {
+	case RouteActionReject:
+		logger.Info("HTTP/2 stream to %s rejected by routing rules", hostPort)
+		http.Error(w, "Blocked by routing rules", http.StatusForbidden)
+		return nil, "", false
+	case RouteActionDirect:
+		conn, err := dialDirect(hostPort)
+		if err != nil {
+			logger.Error("Direct connection to %s failed: %v", hostPort, err)
+			http.Error(w, fmt.Sprintf("Direct connection failed: %v", err), http.StatusBadGateway)
+			return nil, "", false
+		}
+		return conn, "directly", true
+	}
+
+	pm := routed.WithFilter(requestFilterFromHeader(r.Header))
+	conn, proxy, err := dialUpstreamTunnel(pm, hostPort)
+	if err != nil {
+		logger.Error("All HTTP/2 tunnel attempts to %s failed after %d retries, last error: %v", hostPort, pm.maxRetries, err)
+		http.Error(w, fmt.Sprintf("All proxy attempts failed: %v", err), http.StatusBadGateway)
+		return nil, "", false
+	}
+	return conn, "via proxy " + proxy.URL, true
+}
+
+// requestScheme trả về :scheme của stream (với extended CONNECT là scheme của đích ws/wss), không phải
+// trạng thái TLS giữa client và listener. http2.Server dựng r.URL từ :path nên thường không có scheme,
+// còn :scheme chỉ được phản ánh qua r.TLS: khác nil khi và chỉ khi :scheme là "https".
+func requestScheme(r *http.Request) string {
+	if r.URL.Scheme == "http" || r.URL.Scheme == "https" {
+		return r.URL.Scheme
+	}
+	if r.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// pipeStream truyền dữ liệu hai chiều giữa một stream HTTP/2 và kết nối upstream.
+// Chỉ trả về khi chiều upstream -> client đã dừng hẳn vì không được ghi vào
+// ResponseWriter sau khi handler kết thúc
+func pipeStream(w http.ResponseWriter, body io.Reader, upstreamReader io.Reader, upstream net.Conn) {
+	clientDone := make(chan error, 1)
+	upstreamDone := make(chan error, 1)
+
+	// Client -> Upstream
+	go func() {
+		_, err := io.Copy(upstream, body)
+		clientDone <- err
+	}()
+
+	// Upstream -> Client, flush sau mỗi lần ghi để dữ liệu đi ngay
+	go func() {
+		_, err := io.Copy(&flushWriter{w: w}, upstreamReader)
+		upstreamDone <- err
+	}()
+
+	var err error
+	select {
+	case err = <-clientDone:
+		// Client đóng chiều gửi, chờ upstream trả nốt dữ liệu
+		if cw, ok := upstream.(interface{ CloseWrite() error }); ok && err == nil {
+			cw.CloseWrite()
+		} else {
+			upstream.Close()
+		}
+		<-upstreamDone
+	case err = <-upstreamDone:
+	}
+
+	if err != nil && err != io.EOF && !strings.Contains(err.Error(), "closed") {
+		logger.Error("HTTP/2 tunnel error: %v", err)
+	}
+}
+
+// flushWriter flush ResponseWriter sau mỗi lần ghi
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	if err == nil {
+		f.w.(http.Flusher).Flush()
+	}
+	return n, err
+}
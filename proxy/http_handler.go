@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
+	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
+
+	"proxy/utils"
 )
 
 // handleHTTPProxy xử lý các yêu cầu HTTP proxy với tự động thử lại
@@ -48,6 +53,9 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 		}
 	}
 
+	// Bộ lọc GeoIP theo request, các header X-Proxy-* không được chuyển tiếp
+	filter := requestFilterFromMap(headers)
+
 	// Trích xuất URL đích từ dòng đầu tiên
 	parts := strings.Split(firstLine, " ")
 	if len(parts) != 3 {
@@ -70,16 +78,37 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 		host = parsedURL.Host
 	}
 
+	// Định tuyến theo đích và client trước khi chọn proxy
+	action, routed := route(pm, requestHostPort(targetURL, host), clientConn.RemoteAddr().String())
+	switch action {
+	case RouteActionReject:
+		logger.Info("HTTP request to %s rejected by routing rules", host)
+		clientConn.Write([]byte("HTTP/1.1 403 Forbidden\r\n\r\nBlocked by routing rules\r\n"))
+		return
+	case RouteActionDirect:
+		parsedURL, err := url.Parse(targetURL)
+		if err != nil || parsedURL.Host == "" {
+			clientConn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			return
+		}
+		serveHTTPDirect(clientConn, reader, method, parsedURL, host, headers)
+		return
+	}
+	pm = routed.WithFilter(filter).ForDestination(host)
+
+	// Request tới host được bật cache đi qua pipeline cache
+	if c := responseCache.Load(); c != nil {
+		if parsedURL, err := url.Parse(targetURL); err == nil && c.enabledFor(parsedURL.Hostname()) {
+			serveHTTPWithCache(clientConn, reader, method, parsedURL, host, headers, pm)
+			return
+		}
+	}
+
 	// Theo dõi các proxy đã thử để tránh dùng lại chúng khi thử lại
 	triedProxies := make(map[string]bool)
 	var lastError error
 	var lastProxy *Proxy
 
-	// Chỉ chọn proxy HTTP
-	httpOnlySelector := func(p *Proxy) bool {
-		return p.Type == ProxyTypeHTTP || p.Type == ProxyTypeUnknown
-	}
-
 	// Thử tối đa maxRetries lần
 	for retry := 0; retry <= pm.maxRetries; retry++ {
 		// Lấy một proxy, loại trừ những proxy đã thử
@@ -92,7 +121,9 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 				excludeURL = lastProxy.URL
 			}
 			proxy = pm.GetNextWorkingProxyWithFilter(excludeURL, httpOnlySelector)
-			logger.Info("HTTP Retry %d/%d with proxy %s", retry, pm.maxRetries, proxy.URL)
+			if proxy != nil {
+				logger.Info("HTTP Retry %d/%d with proxy %s", retry, pm.maxRetries, proxy.URL)
+			}
 		}
 
 		if proxy == nil {
@@ -103,6 +134,7 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 
 		// Bỏ qua nếu đã thử proxy này
 		if triedProxies[proxy.URL] {
+			proxy.cancelReservation()
 			continue
 		}
 
@@ -114,23 +146,30 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 		if err != nil {
 			logger.Error("Failed to parse proxy URL: %v", err)
 			lastError = err
+			proxy.cancelReservation()
 			pm.MarkProxyFailed(proxy)
 			continue // Thử proxy tiếp theo
 		}
 
 		// Thiết lập xác thực proxy trong URL nếu có thông tin đăng nhập
-		if proxy.Username != "" && proxy.Password != "" {
-			proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+		username, password := proxy.credentials()
+		if username != "" && password != "" {
+			proxyURL.User = url.UserPassword(username, password)
 		}
 
 		// Kết nối tới proxy với timeout
-		proxyConn, err := net.DialTimeout("tcp", proxyURL.Host, 10*time.Second)
+		dialStart := time.Now()
+		proxyConn, err := dialProxy(proxy)
 		if err != nil {
 			logger.Error("Failed to connect to proxy: %v", err)
 			lastError = err
+			proxy.cancelReservation()
 			pm.MarkProxyFailed(proxy)
 			continue // Thử proxy tiếp theo
 		}
+		proxy.recordConnect(time.Since(dialStart))
+
+		proxyConn = proxy.trackConn(proxyConn)
 
 		// Sử dụng defer trong một hàm để đảm bảo kết nối này được đóng trước khi thử proxy khác
 		func() {
@@ -142,14 +181,15 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 			request.WriteString(fmt.Sprintf("Host: %s\r\n", host))
 
 			// Thêm xác thực proxy
-			if proxy.Username != "" && proxy.Password != "" {
-				auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", proxy.Username, proxy.Password)))
+			if username != "" && password != "" {
+				auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
 				request.WriteString(fmt.Sprintf("Proxy-Authorization: Basic %s\r\n", auth))
 			}
 
 			// Thêm các header còn lại
 			for key, value := range headers {
-				if key != "Host" && key != "Proxy-Authorization" {
+				// Tên header không phân biệt hoa thường, Proxy-Authorization của client không được gửi lên upstream
+				if !strings.EqualFold(key, "Host") && !strings.EqualFold(key, "Proxy-Authorization") {
 					request.WriteString(fmt.Sprintf("%s: %s\r\n", key, value))
 				}
 			}
@@ -157,9 +197,10 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 			// Thêm header Connection
 			request.WriteString("Connection: Keep-Alive\r\n\r\n")
 
-			logger.Info("Sending request to proxy: %s", request.String())
+			logger.Info("Sending request to proxy: %s", utils.Redact(request.String()))
 
 			// Gửi request tới proxy với timeout
+			requestStart := time.Now()
 			if err := proxyConn.SetWriteDeadline(time.Now().Add(5 * time.Second)); err != nil {
 				logger.Error("Failed to set write deadline: %v", err)
 				lastError = err
@@ -199,6 +240,8 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 				return // Thử proxy tiếp theo
 			}
 
+			proxy.recordTTFB(time.Since(requestStart))
+
 			// Đặt lại deadline sau khi đọc ban đầu
 			if err := proxyConn.SetReadDeadline(time.Time{}); err != nil {
 				logger.Error("Failed to reset read deadline: %v", err)
@@ -258,3 +301,121 @@ func handleHTTPProxy(clientConn net.Conn, reader *bufio.Reader, firstLine string
 	logger.Error("All HTTP proxy attempts failed after %d retries, last error: %v", pm.maxRetries, lastError)
 	clientConn.Write([]byte(fmt.Sprintf("HTTP/1.1 502 Bad Gateway\r\n\r\nAll proxy attempts failed: %v\r\n", lastError)))
 }
+
+// serveHTTPWithCache xử lý request HTTP qua cache dùng chung, miss thì gửi qua ProxyTransport
+func serveHTTPWithCache(clientConn net.Conn, reader *bufio.Reader, method string, targetURL *url.URL, host string, headers map[string]string, pm *ProxyManager) {
+	req := newClientRequest(clientConn, reader, method, targetURL, host, headers)
+	if req == nil {
+		return
+	}
+
+	resp, err := roundTripWithCache(&ProxyTransport{proxyManager: pm}, req)
+	if err != nil {
+		logger.Error("All HTTP proxy attempts failed: %v", err)
+		clientConn.Write([]byte(fmt.Sprintf("HTTP/1.1 502 Bad Gateway\r\n\r\nAll proxy attempts failed: %v\r\n", err)))
+		return
+	}
+	writeClientResponse(clientConn, resp)
+}
+
+// serveHTTPDirect gửi request HTTP thẳng tới đích cho route direct
+func serveHTTPDirect(clientConn net.Conn, reader *bufio.Reader, method string, targetURL *url.URL, host string, headers map[string]string) {
+	req := newClientRequest(clientConn, reader, method, targetURL, host, headers)
+	if req == nil {
+		return
+	}
+
+	logger.Info("Sending HTTP request directly to %s", targetURL.Host)
+	resp, err := directTransport.RoundTrip(req)
+	if err != nil {
+		logger.Error("Direct HTTP request to %s failed: %v", targetURL.String(), err)
+		clientConn.Write([]byte(fmt.Sprintf("HTTP/1.1 502 Bad Gateway\r\n\r\nDirect connection failed: %v\r\n", err)))
+		return
+	}
+	writeClientResponse(clientConn, resp)
+}
+
+// newClientRequest dựng http.Request từ request HTTP/1.1 thô của client, đọc body theo
+// chunked hoặc Content-Length. Trả về nil sau khi đã báo lỗi cho client nếu request không hợp lệ.
+func newClientRequest(clientConn net.Conn, reader *bufio.Reader, method string, targetURL *url.URL, host string, headers map[string]string) *http.Request {
+	req, err := http.NewRequest(method, targetURL.String(), nil)
+	if err != nil {
+		logger.Error("Failed to build request: %v", err)
+		clientConn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+		return nil
+	}
+	req.Host = host
+
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+	for _, header := range hopHeaders {
+		req.Header.Del(header)
+	}
+
+	// Đọc body theo chunked hoặc Content-Length nếu có, Transfer-Encoding đã bị bỏ khỏi req.Header cùng các header hop-by-hop
+	var body io.Reader
+	if isChunked(headerValue(headers, "Transfer-Encoding")) {
+		body = httputil.NewChunkedReader(reader)
+	} else if length, err := strconv.ParseInt(req.Header.Get("Content-Length"), 10, 64); err == nil && length > 0 {
+		body = io.LimitReader(reader, length)
+	}
+	if body != nil {
+		req.Body = io.NopCloser(body)
+		if err := bufferRequestBody(req, req); err != nil {
+			logger.Error("Failed to read request body: %v", err)
+			clientConn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			return nil
+		}
+	}
+
+	return req
+}
+
+// headerValue trả về giá trị header trong map header thô của client, tên header không phân biệt hoa thường
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// isChunked cho biết Transfer-Encoding kết thúc bằng chunked, khi đó body được đọc theo chunk
+func isChunked(transferEncoding string) bool {
+	codings := strings.Split(transferEncoding, ",")
+	return strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked")
+}
+
+// writeClientResponse ghi phản hồi về client rồi đóng kết nối
+func writeClientResponse(clientConn net.Conn, resp *http.Response) {
+	defer resp.Body.Close()
+
+	for _, header := range hopHeaders {
+		resp.Header.Del(header)
+	}
+	resp.Close = true
+	if err := resp.Write(clientConn); err != nil {
+		logger.Error("Failed to write to client: %v", err)
+	}
+}
+
+// requestHostPort trả về host:port đích của request HTTP dùng cho định tuyến
+func requestHostPort(targetURL, host string) string {
+	if parsedURL, err := url.Parse(targetURL); err == nil && parsedURL.Host != "" {
+		host = parsedURL.Host
+		if parsedURL.Port() == "" {
+			port := "80"
+			if parsedURL.Scheme == "https" {
+				port = "443"
+			}
+			return net.JoinHostPort(parsedURL.Hostname(), port)
+		}
+		return host
+	}
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		return net.JoinHostPort(host, "80")
+	}
+	return host
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ProxyMetadata là thông tin khai báo cho proxy trong file inventory
+type ProxyMetadata struct {
+	Tags      []string  // Nhãn tự do, dùng trong bộ lọc của pool, listener và request
+	Provider  string    // Nhà cung cấp proxy
+	MaxConns  int       // Số kết nối đồng thời tối đa, 0 là không giới hạn
+	CostPerGB float64   // Chi phí mỗi GB lưu lượng
+	ExpiresAt time.Time // Thời điểm proxy hết hạn và không còn được chọn, zero là không hết hạn
+	Notes     string
+	// ProviderID mã proxy phía nhà cung cấp, dùng khi trả lại hoặc thay thế proxy
+	ProviderID string
+}
+
+// expired cho biết proxy đã quá hạn sử dụng khai báo trong inventory
+func (p *Proxy) expired(now time.Time) bool {
+	return !p.ExpiresAt.IsZero() && !now.Before(p.ExpiresAt)
+}
+
+// hasTags kiểm tra proxy có đủ mọi nhãn trong tags, không phân biệt hoa thường
+func (p *Proxy) hasTags(tags []string) bool {
+	for _, tag := range tags {
+		if !containsFold(p.Tags, tag) {
+			return false
+		}
+	}
+	return true
+}
+
+// InventoryFormat xác định định dạng file inventory theo phần mở rộng (.json, .yaml, .yml, .csv)
+func InventoryFormat(filename string) (string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return FormatJSON, nil
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".csv":
+		return FormatCSV, nil
+	default:
+		return "", fmt.Errorf("unknown inventory format for %s, expected .json, .yaml, .yml or .csv", filename)
+	}
+}
+
+// LoadInventoryFiles tải các file inventory vào pool
+func LoadInventoryFiles(filenames []string, pm *ProxyManager) {
+	for _, filename := range filenames {
+		if err := LoadInventory(filename, pm); err != nil {
+			log.Printf("[WARN] Error loading proxy inventory: %v", err)
+		}
+	}
+}
+
+// LoadInventory tải proxy kèm metadata từ file inventory, proxy không khai báo scheme hay type là HTTP.
+// Tải lại cùng file chỉ áp phần thay đổi, xem ReloadSource.
+func LoadInventory(filename string, pm *ProxyManager) error {
+	format, err := InventoryFormat(filename)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("error reading proxy inventory %s: %v", filename, err)
+	}
+
+	proxies, lineErrors, err := ParseProxyData(data, format, ProxyTypeHTTP)
+	for _, lineErr := range lineErrors {
+		log.Printf("[WARN] Invalid proxy in %s %v", filename, lineErr)
+	}
+	if err != nil {
+		return fmt.Errorf("error parsing proxy inventory %s: %v", filename, err)
+	}
+
+	pm.ReloadSource(filename, proxies, len(lineErrors))
+
+	log.Printf("[INFO] Loaded %d proxies from inventory %s", len(proxies), filename)
+	return nil
+}
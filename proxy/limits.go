@@ -0,0 +1,328 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// reservationTTL thời gian giữ chỗ cho một proxy vừa được chọn nhưng chưa mở kết nối,
+	// đủ dài cho timeout kết nối tới proxy; hết hạn thì chỗ được trả lại
+	reservationTTL = 15 * time.Second
+	// limitPollInterval chu kỳ kiểm tra lại khi đang xếp hàng chờ proxy rảnh
+	limitPollInterval = 100 * time.Millisecond
+)
+
+// LimitConfig giới hạn số kết nối đồng thời và số request trong mỗi Interval, 0 là không giới hạn
+type LimitConfig struct {
+	MaxConns    int    `json:"max_conns,omitempty"`
+	MaxRequests int    `json:"max_requests,omitempty"`
+	Interval    string `json:"interval,omitempty"` // Mặc định 1s
+}
+
+// LimitsConfig cấu hình giới hạn sử dụng proxy upstream của một pool
+type LimitsConfig struct {
+	// PerProxy giới hạn mặc định cho mỗi proxy
+	PerProxy *LimitConfig `json:"per_proxy,omitempty"`
+	// PerCredential giới hạn chung cho các proxy dùng cùng username
+	PerCredential *LimitConfig `json:"per_credential,omitempty"`
+	// Proxies ghi đè giới hạn theo địa chỉ proxy ("host:port")
+	Proxies map[string]LimitConfig `json:"proxies,omitempty"`
+	// QueueTimeout thời gian tối đa chờ proxy rảnh khi mọi proxy phù hợp đều bão hòa,
+	// để trống thì trả lỗi ngay
+	QueueTimeout string `json:"queue_timeout,omitempty"`
+}
+
+// limitSettings là LimitConfig đã được phân tích
+type limitSettings struct {
+	maxConns    int
+	maxRequests int
+	interval    time.Duration
+}
+
+func parseLimit(cfg LimitConfig) (*limitSettings, error) {
+	if cfg.MaxConns < 0 || cfg.MaxRequests < 0 {
+		return nil, fmt.Errorf("limits must not be negative")
+	}
+
+	settings := &limitSettings{maxConns: cfg.MaxConns, maxRequests: cfg.MaxRequests, interval: time.Second}
+	if cfg.Interval != "" {
+		interval, err := time.ParseDuration(cfg.Interval)
+		if err != nil || interval <= 0 {
+			return nil, fmt.Errorf("invalid limit interval %q", cfg.Interval)
+		}
+		settings.interval = interval
+	}
+	return settings, nil
+}
+
+// limiter đếm kết nối đang mở, chỗ đã giữ và request gần đây của một proxy hoặc một nhóm credential
+type limiter struct {
+	settings *limitSettings
+	notify   func()
+
+	mu       sync.Mutex
+	active   int
+	reserved []time.Time // Proxy đã được chọn nhưng chưa mở kết nối
+	requests []time.Time // Thời điểm các request trong cửa sổ interval
+}
+
+// prune bỏ các chỗ giữ hết hạn và request ngoài cửa sổ, gọi khi đang giữ l.mu
+func (l *limiter) prune(now time.Time) {
+	for len(l.reserved) > 0 && now.Sub(l.reserved[0]) > reservationTTL {
+		l.reserved = l.reserved[1:]
+	}
+	for len(l.requests) > 0 && now.Sub(l.requests[0]) >= l.settings.interval {
+		l.requests = l.requests[1:]
+	}
+}
+
+// saturated cho biết đã chạm giới hạn kết nối hoặc số request trong cửa sổ
+func (l *limiter) saturated(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	if l.settings.maxConns > 0 && l.active+len(l.reserved) >= l.settings.maxConns {
+		return true
+	}
+	return l.settings.maxRequests > 0 && len(l.requests) >= l.settings.maxRequests
+}
+
+// reserve giữ chỗ cho một lần chọn proxy và tính nó là một request
+func (l *limiter) reserve(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.prune(now)
+	if l.settings.maxConns > 0 {
+		l.reserved = append(l.reserved, now)
+	}
+	if l.settings.maxRequests > 0 {
+		l.requests = append(l.requests, now)
+	}
+}
+
+// acquire chuyển một chỗ đã giữ (nếu có) thành kết nối đang mở
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.reserved) > 0 {
+		l.reserved = l.reserved[1:]
+	}
+	l.active++
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+
+	l.notify()
+}
+
+// cancelReservation trả lại một chỗ đã giữ khi proxy được chọn nhưng không mở được kết nối
+func (l *limiter) cancelReservation() {
+	l.mu.Lock()
+	released := len(l.reserved) > 0
+	if released {
+		l.reserved = l.reserved[1:]
+	}
+	l.mu.Unlock()
+
+	if released {
+		l.notify()
+	}
+}
+
+// poolLimits là cấu hình giới hạn đã phân tích của một pool cùng các limiter theo nhóm credential
+type poolLimits struct {
+	perProxy      *limitSettings
+	perCredential *limitSettings
+	proxies       map[string]*limitSettings
+	queueTimeout  time.Duration
+
+	groups map[string]*limiter
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// notify đánh thức các request đang xếp hàng chờ proxy rảnh
+func (pl *poolLimits) notify() {
+	pl.mu.Lock()
+	close(pl.wake)
+	pl.wake = make(chan struct{})
+	pl.mu.Unlock()
+}
+
+func (pl *poolLimits) waitChan() <-chan struct{} {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	return pl.wake
+}
+
+// SetLimits cấu hình giới hạn kết nối đồng thời và tốc độ request theo proxy, nil để bỏ giới hạn
+func (pm *ProxyManager) SetLimits(cfg *LimitsConfig) error {
+	limits, err := parseLimits(cfg)
+	if err != nil {
+		return err
+	}
+	pm.setLimits(limits)
+	return nil
+}
+
+// parseLimits phân tích cấu hình giới hạn của một pool, nil khi không giới hạn
+func parseLimits(cfg *LimitsConfig) (*poolLimits, error) {
+	var limits *poolLimits
+	if cfg != nil {
+		limits = &poolLimits{
+			proxies: make(map[string]*limitSettings),
+			groups:  make(map[string]*limiter),
+			wake:    make(chan struct{}),
+		}
+
+		var err error
+		if cfg.PerProxy != nil {
+			if limits.perProxy, err = parseLimit(*cfg.PerProxy); err != nil {
+				return nil, fmt.Errorf("per_proxy: %v", err)
+			}
+		}
+		if cfg.PerCredential != nil {
+			if limits.perCredential, err = parseLimit(*cfg.PerCredential); err != nil {
+				return nil, fmt.Errorf("per_credential: %v", err)
+			}
+		}
+		for addr, limit := range cfg.Proxies {
+			if limits.proxies[addr], err = parseLimit(limit); err != nil {
+				return nil, fmt.Errorf("proxy %s: %v", addr, err)
+			}
+		}
+		if cfg.QueueTimeout != "" {
+			if limits.queueTimeout, err = time.ParseDuration(cfg.QueueTimeout); err != nil {
+				return nil, fmt.Errorf("invalid queue_timeout: %v", err)
+			}
+		}
+	}
+	return limits, nil
+}
+
+// setLimits gắn giới hạn đã phân tích cho pool và các proxy hiện có
+func (pm *ProxyManager) setLimits(limits *poolLimits) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.limits = limits
+	for _, proxy := range pm.proxies {
+		pm.applyLimits(proxy)
+	}
+}
+
+// applyLimits gắn limiter theo proxy và theo nhóm credential cho proxy. Gọi khi đang giữ pm.mu.
+// Kết nối đang mở giữ các limiter cũ mà nó đã tính và trả lại đúng các limiter đó khi đóng.
+func (pm *ProxyManager) applyLimits(proxy *Proxy) {
+	var limiters []*limiter
+	defer func() { proxy.limiters.Store(&limiters) }()
+
+	limits := pm.limits
+	if proxy.MaxConns > 0 {
+		// Giới hạn khai báo trong inventory áp dụng cả khi pool không cấu hình limits
+		notify := func() {}
+		if limits != nil {
+			notify = limits.notify
+		}
+		settings := &limitSettings{maxConns: proxy.MaxConns, interval: time.Second}
+		limiters = append(limiters, &limiter{settings: settings, notify: notify})
+	}
+	if limits == nil {
+		return
+	}
+
+	settings := limits.perProxy
+	if override, ok := limits.proxies[proxyAddress(proxy.URL)]; ok {
+		settings = override
+	}
+	if settings != nil {
+		limiters = append(limiters, &limiter{settings: settings, notify: limits.notify})
+	}
+
+	if group := proxy.credentialGroup(); limits.perCredential != nil && group != "" {
+		groupLimiter, ok := limits.groups[group]
+		if !ok {
+			groupLimiter = &limiter{settings: limits.perCredential, notify: limits.notify}
+			limits.groups[group] = groupLimiter
+		}
+		limiters = append(limiters, groupLimiter)
+	}
+}
+
+// currentLimiters trả về các limiter hiện tại của proxy, đọc được mà không cần pm.mu
+func (p *Proxy) currentLimiters() []*limiter {
+	if limiters := p.limiters.Load(); limiters != nil {
+		return *limiters
+	}
+	return nil
+}
+
+// cancelReservation trả lại chỗ giữ lúc chọn proxy khi không dùng tới proxy, ví dụ kết nối tới proxy thất bại
+func (p *Proxy) cancelReservation() {
+	for _, l := range p.currentLimiters() {
+		l.cancelReservation()
+	}
+}
+
+// saturated cho biết proxy hoặc nhóm credential của nó đã chạm giới hạn
+func (p *Proxy) saturated(now time.Time) bool {
+	for _, l := range p.currentLimiters() {
+		if l.saturated(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Saturated cho biết proxy hiện không nhận thêm kết nối do giới hạn
+func (p *Proxy) Saturated() bool {
+	return p.saturated(time.Now())
+}
+
+// waitCandidates giống candidates nhưng khi mọi proxy phù hợp đều bão hòa thì xếp hàng chờ
+// tối đa queue_timeout để có proxy rảnh. Gọi khi đang giữ pm.mu, khóa được nhả trong lúc chờ.
+func (pm *ProxyManager) waitCandidates(excludeURL string, selector ProxySelector) []*Proxy {
+	eligible, saturated := pm.candidates(excludeURL, selector)
+	limits := pm.limits
+	if len(eligible) > 0 || saturated == 0 || limits == nil || limits.queueTimeout <= 0 {
+		if len(eligible) == 0 && saturated > 0 {
+			logger.Warn("All %d matching proxies are saturated", saturated)
+		}
+		return eligible
+	}
+
+	logger.Info("All %d matching proxies are saturated, queueing for up to %v", saturated, limits.queueTimeout)
+	deadline := time.Now().Add(limits.queueTimeout)
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			logger.Warn("Timed out waiting for a free proxy after %v", limits.queueTimeout)
+			return nil
+		}
+		if wait > limitPollInterval {
+			wait = limitPollInterval
+		}
+
+		wake := limits.waitChan()
+		pm.mu.Unlock()
+		select {
+		case <-wake:
+		case <-time.After(wait):
+		}
+		pm.mu.Lock()
+
+		eligible, saturated = pm.candidates(excludeURL, selector)
+		if len(eligible) > 0 || saturated == 0 {
+			return eligible
+		}
+	}
+}
@@ -1,12 +1,9 @@
 package proxy
 
 import (
-	"bufio"
 	"fmt"
 	"log"
 	"os"
-	"strings"
-	"time"
 )
 
 // ProxyType định nghĩa loại proxy
@@ -14,6 +11,8 @@ type ProxyType string
 
 const (
 	ProxyTypeHTTP    ProxyType = "http"
+	ProxyTypeHTTPS   ProxyType = "https"  // Proxy HTTP nhận kết nối TLS
+	ProxyTypeSOCKS4  ProxyType = "socks4" // Gồm cả socks4a
 	ProxyTypeSOCKS5  ProxyType = "socks5"
 	ProxyTypeUnknown ProxyType = "unknown"
 )
@@ -47,7 +46,8 @@ func LoadProxies(filename string, pm *ProxyManager) error {
 	return LoadProxiesWithType(filename, ProxyTypeHTTP, pm)
 }
 
-// LoadProxiesWithType tải proxy từ file với type xác định
+// LoadProxiesWithType tải proxy từ file với type xác định. Tải lại cùng file chỉ áp phần thay đổi,
+// xem ReloadSource.
 func LoadProxiesWithType(filename string, proxyType ProxyType, pm *ProxyManager) error {
 	file, err := os.Open(filename)
 	if err != nil {
@@ -55,95 +55,16 @@ func LoadProxiesWithType(filename string, proxyType ProxyType, pm *ProxyManager)
 	}
 	defer file.Close()
 
-	var proxies []*Proxy
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue // Bỏ qua dòng trống và comment
-		}
-
-		// Parse proxy URL dựa vào định dạng
-		proxy, err := ParseProxy(line)
-		if err != nil {
-			log.Printf("[WARN] Invalid proxy format in %s: %s, error: %v", filename, line, err)
-			continue
-		}
-
-		// Gán type cho proxy
-		proxy.Type = proxyType
-		proxy.IsWorking = true // Giả định hoạt động ban đầu
-
-		// Đảm bảo URL có prefix đúng với loại proxy
-		if !strings.Contains(proxy.URL, "://") {
-			if proxyType == ProxyTypeSOCKS5 {
-				proxy.URL = "socks5://" + proxy.URL
-			} else {
-				proxy.URL = "http://" + proxy.URL
-			}
-		}
-
-		proxies = append(proxies, proxy)
+	proxies, lineErrors, err := ParseProxyList(file, proxyType)
+	for _, lineErr := range lineErrors {
+		log.Printf("[WARN] Invalid proxy in %s %v", filename, lineErr)
 	}
-
-	if err := scanner.Err(); err != nil {
+	if err != nil {
 		return fmt.Errorf("error reading proxy file %s: %v", filename, err)
 	}
 
-	pm.mu.Lock()
-	for _, proxy := range proxies {
-		pm.AddProxy(proxy)
-	}
-	pm.mu.Unlock()
+	pm.ReloadSource(filename, proxies, len(lineErrors))
 
 	log.Printf("[INFO] Loaded %d %s proxies from %s", len(proxies), proxyType, filename)
 	return nil
 }
-
-// MonitorProxyList giám sát các file proxy để cập nhật
-func MonitorProxyList(httpFile, socks5File string, pm *ProxyManager) {
-	var lastModHTTP, lastModSOCKS5 time.Time
-
-	// Lấy thời gian sửa đổi ban đầu
-	if httpFile != "" {
-		if stat, err := os.Stat(httpFile); err == nil {
-			lastModHTTP = stat.ModTime()
-		}
-	}
-
-	if socks5File != "" {
-		if stat, err := os.Stat(socks5File); err == nil {
-			lastModSOCKS5 = stat.ModTime()
-		}
-	}
-
-	for {
-		time.Sleep(5 * time.Second)
-
-		// Kiểm tra file HTTP proxy
-		if httpFile != "" {
-			if stat, err := os.Stat(httpFile); err == nil {
-				if stat.ModTime() != lastModHTTP {
-					log.Printf("[INFO] HTTP proxy file %s changed, reloading", httpFile)
-					if err := LoadProxiesWithType(httpFile, ProxyTypeHTTP, pm); err != nil {
-						log.Printf("[ERROR] Error reloading HTTP proxies: %v", err)
-					}
-					lastModHTTP = stat.ModTime()
-				}
-			}
-		}
-
-		// Kiểm tra file SOCKS5 proxy
-		if socks5File != "" {
-			if stat, err := os.Stat(socks5File); err == nil {
-				if stat.ModTime() != lastModSOCKS5 {
-					log.Printf("[INFO] SOCKS5 proxy file %s changed, reloading", socks5File)
-					if err := LoadProxiesWithType(socks5File, ProxyTypeSOCKS5, pm); err != nil {
-						log.Printf("[ERROR] Error reloading SOCKS5 proxies: %v", err)
-					}
-					lastModSOCKS5 = stat.ModTime()
-				}
-			}
-		}
-	}
-}
@@ -0,0 +1,341 @@
+package proxy
+
+import (
+	"bufio"
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MITMConfig cấu hình chế độ giải mã HTTPS cho các đích được chọn
+type MITMConfig struct {
+	CACertFile string `json:"ca_cert_file"`
+	CAKeyFile  string `json:"ca_key_file"`
+
+	// Hosts danh sách mẫu đích cần giải mã: "example.com", "*.example.com" hoặc "*"
+	Hosts []string `json:"hosts"`
+
+	// CertCacheSize số chứng chỉ leaf tối đa được giữ trong cache
+	CertCacheSize int `json:"cert_cache_size,omitempty"`
+}
+
+// mitmInterceptor giữ CA và cache chứng chỉ leaf sinh ra theo từng host
+type mitmInterceptor struct {
+	cfg     MITMConfig
+	hosts   []string
+	ca      *x509.Certificate
+	caKey   *ecdsa.PrivateKey
+	leafKey *ecdsa.PrivateKey
+
+	mu        sync.Mutex
+	cacheSize int
+	cache     map[string]*list.Element
+	lru       *list.List
+}
+
+type certCacheEntry struct {
+	host string
+	cert *tls.Certificate
+}
+
+// mitm là interceptor đang dùng, nil khi tắt MITM; được thay khi tải lại cấu hình
+var mitm atomic.Pointer[mitmInterceptor]
+
+// ConfigureMITM bật chế độ giải mã HTTPS, tạo CA mới nếu chưa có file. Cấu hình không đổi thì giữ
+// interceptor hiện tại cùng cache chứng chỉ leaf.
+func ConfigureMITM(cfg *MITMConfig) error {
+	interceptor, err := newMITMInterceptor(cfg)
+	if err != nil {
+		return err
+	}
+	setMITM(interceptor)
+	return nil
+}
+
+// newMITMInterceptor tạo interceptor theo cấu hình mà chưa dùng nó, trả về interceptor hiện tại nếu
+// cấu hình không đổi và nil khi cfg nil
+func newMITMInterceptor(cfg *MITMConfig) (*mitmInterceptor, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if current := mitm.Load(); current != nil && reflect.DeepEqual(current.cfg, *cfg) {
+		return current, nil
+	}
+
+	ca, caKey, err := loadOrCreateCA(cfg.CACertFile, cfg.CAKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate leaf key: %v", err)
+	}
+
+	cacheSize := cfg.CertCacheSize
+	if cacheSize <= 0 {
+		cacheSize = 1000
+	}
+
+	return &mitmInterceptor{
+		cfg:       *cfg,
+		hosts:     cfg.Hosts,
+		ca:        ca,
+		caKey:     caKey,
+		leafKey:   leafKey,
+		cacheSize: cacheSize,
+		cache:     make(map[string]*list.Element),
+		lru:       list.New(),
+	}, nil
+}
+
+// setMITM dùng interceptor cho các kết nối mới, nil để tắt MITM
+func setMITM(interceptor *mitmInterceptor) {
+	if mitm.Swap(interceptor) != interceptor && interceptor != nil {
+		logger.Info("MITM enabled for %d host patterns with CA %s", len(interceptor.cfg.Hosts), interceptor.cfg.CACertFile)
+	}
+}
+
+// loadOrCreateCA đọc CA từ file, nếu chưa tồn tại thì sinh CA mới và ghi ra file
+func loadOrCreateCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		return createCA(certFile, keyFile)
+	}
+	if certErr != nil {
+		return nil, nil, fmt.Errorf("failed to read CA certificate: %v", certErr)
+	}
+	if keyErr != nil {
+		return nil, nil, fmt.Errorf("failed to read CA key: %v", keyErr)
+	}
+
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CA key pair: %v", err)
+	}
+
+	ca, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid CA certificate: %v", err)
+	}
+
+	caKey, ok := pair.PrivateKey.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("CA key must be an ECDSA key")
+	}
+
+	return ca, caKey, nil
+}
+
+func createCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate CA key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{CommonName: "Proxy Server Local CA", Organization: []string{"Proxy Server"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create CA certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encode CA key: %v", err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		return nil, nil, fmt.Errorf("failed to write CA certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return nil, nil, fmt.Errorf("failed to write CA key: %v", err)
+	}
+
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logger.Info("Generated new MITM CA at %s, install it as trusted on clients", certFile)
+	return ca, caKey, nil
+}
+
+func randomSerial() *big.Int {
+	serial, _ := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	return serial
+}
+
+// matchHostPattern kiểm tra host có khớp mẫu "*", "*.example.com" hoặc "example.com"
+func matchHostPattern(pattern, host string) bool {
+	pattern = strings.ToLower(pattern)
+	host = strings.ToLower(host)
+
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// shouldIntercept kiểm tra có cần giải mã kết nối tới host hay không
+func (m *mitmInterceptor) shouldIntercept(host string) bool {
+	for _, pattern := range m.hosts {
+		if matchHostPattern(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// certificate trả về chứng chỉ leaf cho host, sinh mới nếu chưa có trong cache
+func (m *mitmInterceptor) certificate(host string) (*tls.Certificate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.cache[host]; ok {
+		m.lru.MoveToFront(elem)
+		return elem.Value.(*certCacheEntry).cert, nil
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	if template.NotAfter.After(m.ca.NotAfter) {
+		template.NotAfter = m.ca.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, m.ca, &m.leafKey.PublicKey, m.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mint certificate for %s: %v", host, err)
+	}
+
+	cert := &tls.Certificate{
+		Certificate: [][]byte{der, m.ca.Raw},
+		PrivateKey:  m.leafKey,
+	}
+
+	m.cache[host] = m.lru.PushFront(&certCacheEntry{host: host, cert: cert})
+	if m.lru.Len() > m.cacheSize {
+		oldest := m.lru.Back()
+		m.lru.Remove(oldest)
+		delete(m.cache, oldest.Value.(*certCacheEntry).host)
+	}
+
+	return cert, nil
+}
+
+// serve giải mã tunnel CONNECT và chuyển từng request qua ProxyTransport
+func (m *mitmInterceptor) serve(clientConn net.Conn, hostPort string, pm *ProxyManager) {
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host, port = hostPort, "443"
+	}
+
+	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+
+	tlsConn := tls.Server(clientConn, &tls.Config{
+		NextProtos: []string{"http/1.1"},
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			name := hello.ServerName
+			if name == "" {
+				name = host
+			}
+			return m.certificate(name)
+		},
+	})
+	if err := tlsConn.Handshake(); err != nil {
+		logger.Error("MITM TLS handshake for %s failed: %v", hostPort, err)
+		return
+	}
+	defer tlsConn.Close()
+
+	logger.Info("MITM intercepting %s", hostPort)
+
+	transport := &ProxyTransport{proxyManager: pm}
+	reader := bufio.NewReader(tlsConn)
+	for {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			if err != io.EOF {
+				logger.Error("Failed to read intercepted request: %v", err)
+			}
+			return
+		}
+
+		req.URL.Scheme = "https"
+		req.URL.Host = req.Host
+		if req.URL.Host == "" {
+			req.URL.Host = host
+		}
+		if port != "443" && !strings.Contains(req.URL.Host, ":") {
+			req.URL.Host = net.JoinHostPort(req.URL.Host, port)
+		}
+		req.RequestURI = ""
+
+		if err := bufferRequestBody(req, req); err != nil {
+			logger.Error("Failed to read intercepted request body: %v", err)
+			return
+		}
+
+		resp, err := roundTripWithCache(transport, req)
+		if err != nil {
+			logger.Error("MITM request to %s failed: %v", req.URL.String(), err)
+			fmt.Fprintf(tlsConn, "HTTP/1.1 502 Bad Gateway\r\nConnection: close\r\n\r\nAll proxy attempts failed: %v\r\n", err)
+			return
+		}
+
+		for _, header := range hopHeaders {
+			resp.Header.Del(header)
+		}
+		err = resp.Write(tlsConn)
+		resp.Body.Close()
+		if err != nil {
+			logger.Error("Failed to write intercepted response: %v", err)
+			return
+		}
+
+		if req.Close || resp.Close {
+			return
+		}
+	}
+}
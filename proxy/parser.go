@@ -1,53 +1,232 @@
 package proxy
 
 import (
+	"bufio"
 	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
 
-// ParseProxy parses a proxy string into a Proxy struct
+// proxySchemes ánh xạ scheme trong danh sách proxy sang loại proxy
+var proxySchemes = map[string]ProxyType{
+	"http":    ProxyTypeHTTP,
+	"https":   ProxyTypeHTTPS,
+	"socks4":  ProxyTypeSOCKS4,
+	"socks4a": ProxyTypeSOCKS4,
+	"socks5":  ProxyTypeSOCKS5,
+	"socks5h": ProxyTypeSOCKS5,
+}
+
+// ParseProxyType đọc loại proxy theo tên scheme (http, https, socks4, socks4a, socks5, socks5h)
+func ParseProxyType(name string) (ProxyType, error) {
+	if t, ok := proxySchemes[strings.ToLower(name)]; ok {
+		return t, nil
+	}
+	return ProxyTypeUnknown, fmt.Errorf("unsupported proxy type %q", name)
+}
+
+// ParseProxy phân tích một dòng trong danh sách proxy. Các định dạng được hỗ trợ:
+//
+//	scheme://[user:pass@]host:port  (http, https, socks4, socks4a, socks5, socks5h)
+//	user:pass@host:port
+//	host:port:user:pass
+//	host:port
+//
+// Host IPv6 phải nằm trong ngoặc vuông, ví dụ [::1]:1080. Password có thể chứa ':' và '@'.
+// Loại proxy lấy theo scheme, không có scheme thì là ProxyTypeUnknown và URL không có scheme.
 func ParseProxy(line string) (*Proxy, error) {
 	line = strings.TrimSpace(line)
 	if line == "" || strings.HasPrefix(line, "#") {
 		return nil, fmt.Errorf("empty line or comment")
 	}
 
-	// Check if proxy URL contains @ (user:pass@host:port format)
-	if strings.Contains(line, "@") {
-		parts := strings.Split(line, "@")
-		if len(parts) == 2 {
-			auth := strings.Split(parts[0], ":")
-			if len(auth) == 2 {
-				return &Proxy{
-					URL:      parts[1],
-					Username: auth[0],
-					Password: auth[1],
-					LastUsed: time.Time{},
-					Type:     ProxyTypeUnknown,
-				}, nil
-			}
-		}
-	} else if strings.Count(line, ":") == 3 {
-		// ip:port:user:pass format
-		parts := strings.Split(line, ":")
-		if len(parts) == 4 {
-			return &Proxy{
-				URL:      fmt.Sprintf("%s:%s", parts[0], parts[1]),
-				Username: parts[2],
-				Password: parts[3],
-				LastUsed: time.Time{},
-				Type:     ProxyTypeUnknown,
-			}, nil
+	proxyType := ProxyTypeUnknown
+	scheme := ""
+	rest := line
+	if i := strings.Index(line, "://"); i >= 0 {
+		scheme = strings.ToLower(line[:i])
+		t, ok := proxySchemes[scheme]
+		if !ok {
+			return nil, fmt.Errorf("unsupported scheme %q", scheme)
+		}
+		proxyType = t
+		rest = strings.TrimSuffix(line[i+3:], "/")
+	}
+
+	var username, password, hostPort string
+	if at := strings.LastIndex(rest, "@"); at >= 0 && isHostPort(rest[at+1:]) && !hasHostPortPrefix(rest[:at]) {
+		// user:pass@host:port, tách ở '@' cuối và ':' đầu để password chứa được ':' và '@'.
+		// Dạng host:port:user:pass có '@' trong password được xử lý ở nhánh dưới.
+		userInfo := rest[:at]
+		hostPort = rest[at+1:]
+		var ok bool
+		username, password, ok = strings.Cut(userInfo, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("invalid credentials, expected user:pass@host:port")
+		}
+		if scheme != "" {
+			username = unescapeUserInfo(username)
+			password = unescapeUserInfo(password)
+		}
+	} else {
+		var err error
+		hostPort, username, password, err = splitHostPortCredentials(rest)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	host, port, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %v", hostPort, err)
+	}
+	if host == "" {
+		return nil, fmt.Errorf("missing host in %q", hostPort)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return nil, fmt.Errorf("invalid port %q", port)
+	}
+
+	proxyURL := net.JoinHostPort(host, port)
+	if scheme != "" {
+		proxyURL = scheme + "://" + proxyURL
+	}
+
+	proxy := &Proxy{
+		URL:      proxyURL,
+		Username: username,
+		Password: password,
+		LastUsed: time.Time{},
+		Type:     proxyType,
+	}
+	if err := proxy.resolveCredentials(); err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+// LineError là lỗi phân tích một dòng trong danh sách proxy, không chứa nội dung dòng
+// để tránh lộ thông tin đăng nhập trong log
+type LineError struct {
+	Line int
+	Err  error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+}
+
+// ParseProxyList đọc danh sách proxy mỗi dòng một proxy, bỏ qua dòng trống và comment.
+// Proxy không có scheme được gán defaultType. Dòng không hợp lệ được trả về trong danh sách lỗi.
+func ParseProxyList(r io.Reader, defaultType ProxyType) ([]*Proxy, []*LineError, error) {
+	proxies, _, lineErrors, err := parseProxyLines(r, defaultType)
+	return proxies, lineErrors, err
+}
+
+// parseProxyLines như ParseProxyList, trả thêm số dòng của từng proxy
+func parseProxyLines(r io.Reader, defaultType ProxyType) ([]*Proxy, []int, []*LineError, error) {
+	var proxies []*Proxy
+	var lines []int
+	var lineErrors []*LineError
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue // Bỏ qua dòng trống và comment
+		}
+
+		proxy, err := ParseProxy(line)
+		if err != nil {
+			lineErrors = append(lineErrors, &LineError{Line: lineNumber, Err: err})
+			continue
+		}
+		proxy.applyDefaultType(defaultType)
+		proxies = append(proxies, proxy)
+		lines = append(lines, lineNumber)
+	}
+
+	return proxies, lines, lineErrors, scanner.Err()
+}
+
+// applyDefaultType gán loại và scheme cho proxy khai báo không có scheme
+func (p *Proxy) applyDefaultType(proxyType ProxyType) {
+	if p.Type != ProxyTypeUnknown || proxyType == ProxyTypeUnknown {
+		return
+	}
+	p.Type = proxyType
+	if !strings.Contains(p.URL, "://") {
+		p.URL = string(proxyType) + "://" + p.URL
+	}
+}
+
+// splitHostPortCredentials tách dạng host:port hoặc host:port:user:pass (host có thể là [IPv6]),
+// phần sau dấu ':' thứ ba đều thuộc password
+func splitHostPortCredentials(s string) (hostPort, username, password string, err error) {
+	// Độ dài phần host:port
+	end := -1
+	if strings.HasPrefix(s, "[") {
+		closing := strings.Index(s, "]")
+		if closing < 0 {
+			return "", "", "", fmt.Errorf("missing ']' in IPv6 address")
+		}
+		colon := strings.Index(s[closing:], ":")
+		if colon < 0 {
+			return "", "", "", fmt.Errorf("missing port after IPv6 address")
+		}
+		if next := strings.Index(s[closing+colon+1:], ":"); next >= 0 {
+			end = closing + colon + 1 + next
 		}
 	} else {
-		// ip:port or domain:port format
-		return &Proxy{
-			URL:      line,
-			LastUsed: time.Time{},
-			Type:     ProxyTypeUnknown,
-		}, nil
+		switch strings.Count(s, ":") {
+		case 0:
+			return "", "", "", fmt.Errorf("missing port")
+		case 1:
+		case 2:
+			return "", "", "", fmt.Errorf("invalid format, expected host:port:user:pass")
+		default:
+			first := strings.Index(s, ":")
+			end = first + 1 + strings.Index(s[first+1:], ":")
+		}
+	}
+
+	if end < 0 {
+		return s, "", "", nil
+	}
+
+	username, password, ok := strings.Cut(s[end+1:], ":")
+	if !ok || username == "" {
+		return "", "", "", fmt.Errorf("invalid format, expected host:port:user:pass")
 	}
+	return s[:end], username, password, nil
+}
+
+// isHostPort cho biết s là host:port hợp lệ
+func isHostPort(s string) bool {
+	host, port, err := net.SplitHostPort(s)
+	if err != nil || host == "" {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n >= 1 && n <= 65535
+}
 
-	return nil, fmt.Errorf("invalid proxy format")
+// hasHostPortPrefix cho biết s bắt đầu bằng host:port theo sau là thông tin đăng nhập như dạng host:port:user:pass
+func hasHostPortPrefix(s string) bool {
+	hostPort, username, _, err := splitHostPortCredentials(s)
+	return err == nil && username != "" && isHostPort(hostPort)
+}
+
+// unescapeUserInfo giải mã percent-encoding trong thông tin đăng nhập của URL, giữ nguyên nếu không hợp lệ
+func unescapeUserInfo(s string) string {
+	if decoded, err := url.PathUnescape(s); err == nil {
+		return decoded
+	}
+	return s
 }
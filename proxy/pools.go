@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+)
+
+// PoolConfig cấu hình một pool proxy có tên. Pool có file proxy riêng được quản lý độc lập
+// (breaker, health check, thống kê); pool không có file là một góc nhìn đã lọc của pool mặc định.
+type PoolConfig struct {
+	Name            string `json:"name"`
+	HTTPProxyFile   string `json:"http_proxy_file,omitempty"`
+	SOCKS5ProxyFile string `json:"socks5_proxy_file,omitempty"`
+	// InventoryFiles các file inventory riêng của pool
+	InventoryFiles []string `json:"inventory_files,omitempty"`
+
+	// Strategy ghi đè strategy chọn proxy cho pool này
+	Strategy string `json:"strategy,omitempty"`
+	// Filter chỉ dùng các proxy thỏa bộ lọc GeoIP
+	Filter *ProxyFilter `json:"filter,omitempty"`
+}
+
+// HasProxyFiles cho biết pool có danh sách proxy riêng hay dùng chung pool mặc định
+func (pc PoolConfig) HasProxyFiles() bool {
+	return pc.HTTPProxyFile != "" || pc.SOCKS5ProxyFile != "" || len(pc.InventoryFiles) > 0
+}
+
+// LoadPools tạo các pool có tên theo cấu hình, pm là pool mặc định
+func LoadPools(pm *ProxyManager, cfg *Config) (map[string]*ProxyManager, error) {
+	pools := make(map[string]*ProxyManager, len(cfg.Pools))
+
+	for _, pc := range cfg.Pools {
+		if pc.Name == "" || pc.Name == DefaultPoolName {
+			return nil, fmt.Errorf("pool name must be non-empty and not %q", DefaultPoolName)
+		}
+		if _, ok := pools[pc.Name]; ok {
+			return nil, fmt.Errorf("duplicate pool %q", pc.Name)
+		}
+
+		pool := pm
+		if pc.HasProxyFiles() {
+			pool = NewProxyManager()
+			if err := applyPoolSettings(pool, cfg); err != nil {
+				return nil, fmt.Errorf("pool %s: %v", pc.Name, err)
+			}
+			LoadInventoryFiles(pc.InventoryFiles, pool)
+			if err := LoadProxiesFromMultipleFiles(pc.HTTPProxyFile, pc.SOCKS5ProxyFile, pool); err != nil {
+				if !cfg.hasRemoteSources(pc.Name) {
+					return nil, fmt.Errorf("pool %s: %v", pc.Name, err)
+				}
+				log.Printf("[WARN] Pool %s: %v, relying on subscriptions, providers and gateway upstreams", pc.Name, err)
+			}
+		}
+
+		if pc.Strategy != "" {
+			strategy, err := NewStrategy(pc.Strategy)
+			if err != nil {
+				return nil, fmt.Errorf("pool %s: %v", pc.Name, err)
+			}
+			pool = pool.WithStrategy(strategy)
+		}
+		pool = pool.WithFilter(pc.Filter)
+
+		pools[pc.Name] = pool
+		log.Printf("[INFO] Pool %s ready with %d proxies", pc.Name, pool.GetProxyCount())
+	}
+
+	return pools, nil
+}
+
+// hasRemoteSources cho biết pool có nhận proxy từ subscription, provider hoặc gateway upstream hay không
+func (cfg *Config) hasRemoteSources(pool string) bool {
+	for _, sc := range cfg.Subscriptions {
+		if sc.Pool == pool {
+			return true
+		}
+	}
+	for _, pc := range cfg.Providers {
+		if pc.Pool == pool {
+			return true
+		}
+	}
+	for _, gc := range cfg.GatewayUpstreams {
+		if gc.Pool == pool {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,327 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotSupported được trả về khi nhà cung cấp không hỗ trợ thao tác
+var ErrNotSupported = errors.New("operation not supported by provider")
+
+// AllocationCriteria là tiêu chí khi xin cấp proxy mới
+type AllocationCriteria struct {
+	Country string
+	Tags    []string
+}
+
+// Provider là API của nhà cung cấp cho phép liệt kê, cấp phát, trả lại và thay thế proxy.
+// Thao tác không hỗ trợ trả về ErrNotSupported.
+type Provider interface {
+	// List trả về các proxy đang được cấp cho tài khoản
+	List(ctx context.Context) ([]*Proxy, error)
+	// Allocate xin cấp thêm count proxy thỏa criteria
+	Allocate(ctx context.Context, count int, criteria AllocationCriteria) ([]*Proxy, error)
+	// Release trả proxy lại cho nhà cung cấp
+	Release(ctx context.Context, proxy *Proxy) error
+	// Replace đổi proxy lấy một proxy mới tương đương
+	Replace(ctx context.Context, proxy *Proxy) (*Proxy, error)
+}
+
+// ProviderTarget số proxy cần duy trì cho một quốc gia và/hoặc tập nhãn
+type ProviderTarget struct {
+	Country string   `json:"country,omitempty"`
+	Tags    []string `json:"tags,omitempty"`
+	Count   int      `json:"count"`
+}
+
+// ProviderConfig cấu hình một nhà cung cấp proxy động
+type ProviderConfig struct {
+	Name string `json:"name"`
+	// Type loại adapter, hiện hỗ trợ "http-json"
+	Type string `json:"type"`
+	// Pool pool nhận proxy (pool mặc định hoặc pool có file riêng), mặc định là pool mặc định
+	Pool string `json:"pool,omitempty"`
+	// Interval chu kỳ đối soát, mặc định 1m
+	Interval string `json:"interval,omitempty"`
+	// Targets số proxy cần duy trì, thiếu thì xin cấp thêm
+	Targets []ProviderTarget `json:"targets,omitempty"`
+	// ReplaceRetired đổi proxy bị retire bằng replace thay vì trả lại rồi xin cấp mới
+	ReplaceRetired bool `json:"replace_retired,omitempty"`
+
+	HTTP *HTTPProviderConfig `json:"http,omitempty"`
+}
+
+// newProvider tạo adapter theo loại cấu hình
+func newProvider(cfg ProviderConfig) (Provider, error) {
+	switch cfg.Type {
+	case "http-json":
+		if cfg.HTTP == nil {
+			return nil, fmt.Errorf("missing http settings")
+		}
+		return NewHTTPProvider(*cfg.HTTP)
+	default:
+		return nil, fmt.Errorf("unknown provider type %q", cfg.Type)
+	}
+}
+
+// ProviderSync giữ các proxy của một nhà cung cấp trong pool: duy trì đủ số lượng theo target,
+// trả lại (hoặc thay thế) các proxy bị ProxyManager retire hay đã hết hạn.
+type ProviderSync struct {
+	name     string
+	source   string
+	provider Provider
+	pm       *ProxyManager
+	targets  []ProviderTarget
+	replace  bool
+	interval time.Duration
+	timeout  time.Duration
+
+	// owned các proxy đang được cấp theo URL, nil khi chưa liệt kê được từ nhà cung cấp
+	owned map[string]*Proxy
+	// changed đánh dấu owned đã đổi từ lần áp vào pool gần nhất
+	changed bool
+
+	refresh  chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// StartProviders khởi động đối soát cho các nhà cung cấp, pools là các pool có danh sách proxy riêng.
+// Lần đối soát đầu tiên chạy đồng bộ để proxy có sẵn trước khi nhận request.
+func StartProviders(cfgs []ProviderConfig, pools map[string]*ProxyManager) ([]*ProviderSync, error) {
+	syncs := make([]*ProviderSync, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		s, err := newProviderSync(cfg, pools)
+		if err != nil {
+			for _, started := range syncs {
+				started.Stop()
+			}
+			return nil, err
+		}
+
+		s.reconcile()
+		go s.run()
+		syncs = append(syncs, s)
+	}
+	return syncs, nil
+}
+
+func newProviderSync(cfg ProviderConfig, pools map[string]*ProxyManager) (*ProviderSync, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("provider name must not be empty")
+	}
+
+	provider, err := newProvider(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: %v", cfg.Name, err)
+	}
+
+	poolName := cfg.Pool
+	if poolName == "" {
+		poolName = DefaultPoolName
+	}
+	pm, ok := pools[poolName]
+	if !ok {
+		return nil, fmt.Errorf("provider %s: unknown pool %q or pool has no proxy files", cfg.Name, poolName)
+	}
+
+	for _, target := range cfg.Targets {
+		if target.Count < 0 {
+			return nil, fmt.Errorf("provider %s: target count must not be negative", cfg.Name)
+		}
+	}
+
+	interval, err := parseSubscriptionDuration(cfg.Interval, time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("provider %s: invalid interval: %v", cfg.Name, err)
+	}
+
+	return &ProviderSync{
+		name:     cfg.Name,
+		source:   "provider:" + cfg.Name,
+		provider: provider,
+		pm:       pm,
+		targets:  cfg.Targets,
+		replace:  cfg.ReplaceRetired,
+		interval: interval,
+		timeout:  time.Minute,
+		refresh:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+func (s *ProviderSync) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.refresh:
+		}
+		s.reconcile()
+	}
+}
+
+// reconcile liệt kê proxy lần đầu, trả lại proxy bị retire, xin cấp cho đủ target rồi áp vào pool
+func (s *ProviderSync) reconcile() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	if s.owned == nil {
+		proxies, err := s.provider.List(ctx)
+		if err != nil && !errors.Is(err, ErrNotSupported) {
+			logger.Error("Provider %s: failed to list proxies: %v", s.name, err)
+			return
+		}
+		s.owned = make(map[string]*Proxy, len(proxies))
+		for _, proxy := range proxies {
+			s.adopt(proxy, AllocationCriteria{})
+		}
+		s.changed = true
+		logger.Info("Provider %s: %d proxies currently allocated", s.name, len(proxies))
+	}
+
+	s.releaseRetired(ctx)
+	s.topUp(ctx)
+	if !s.changed {
+		return
+	}
+
+	proxies := make([]*Proxy, 0, len(s.owned))
+	for _, proxy := range s.owned {
+		proxies = append(proxies, proxy)
+	}
+	s.pm.ReloadSource(s.source, proxies, 0)
+	s.changed = false
+}
+
+// poolStates trả về trạng thái trong pool của các proxy đang được cấp, proxy hết hạn tính là retired
+func (s *ProviderSync) poolStates() map[string]BreakerState {
+	s.pm.mu.RLock()
+	defer s.pm.mu.RUnlock()
+
+	now := time.Now()
+	states := make(map[string]BreakerState, len(s.owned))
+	for _, proxy := range s.pm.proxies {
+		if _, ok := s.owned[proxy.URL]; !ok {
+			continue
+		}
+		states[proxy.URL] = proxy.State
+		if proxy.expired(now) {
+			states[proxy.URL] = BreakerRetired
+		}
+	}
+	return states
+}
+
+// releaseRetired trả lại hoặc thay thế các proxy bị retire, lỗi thì thử lại ở lần đối soát sau
+func (s *ProviderSync) releaseRetired(ctx context.Context) {
+	for url, state := range s.poolStates() {
+		if state != BreakerRetired {
+			continue
+		}
+		proxy := s.owned[url]
+
+		if s.replace {
+			replacement, err := s.provider.Replace(ctx, proxy)
+			if err == nil {
+				delete(s.owned, url)
+				s.adopt(replacement, AllocationCriteria{Country: proxy.Country, Tags: proxy.Tags})
+				logger.Info("Provider %s: replaced retired proxy %s with %s", s.name, url, replacement.URL)
+				continue
+			}
+			if !errors.Is(err, ErrNotSupported) {
+				logger.Error("Provider %s: failed to replace %s: %v", s.name, url, err)
+				continue
+			}
+		}
+
+		if err := s.provider.Release(ctx, proxy); err != nil && !errors.Is(err, ErrNotSupported) {
+			logger.Error("Provider %s: failed to release %s: %v", s.name, url, err)
+			continue
+		}
+		delete(s.owned, url)
+		s.changed = true
+		logger.Info("Provider %s: released retired proxy %s", s.name, url)
+	}
+}
+
+// topUp xin cấp thêm proxy cho các target còn thiếu, proxy bị retire không được tính
+func (s *ProviderSync) topUp(ctx context.Context) {
+	states := s.poolStates()
+	for _, target := range s.targets {
+		have := 0
+		for url, proxy := range s.owned {
+			if state, ok := states[url]; ok && state == BreakerRetired {
+				continue
+			}
+			if target.Country != "" && !strings.EqualFold(proxy.Country, target.Country) {
+				continue
+			}
+			if proxy.hasTags(target.Tags) {
+				have++
+			}
+		}
+		if have >= target.Count {
+			continue
+		}
+
+		criteria := AllocationCriteria{Country: target.Country, Tags: target.Tags}
+		allocated, err := s.provider.Allocate(ctx, target.Count-have, criteria)
+		if err != nil {
+			logger.Error("Provider %s: failed to allocate %d proxies (country %q, tags %v): %v",
+				s.name, target.Count-have, target.Country, target.Tags, err)
+			continue
+		}
+		for _, proxy := range allocated {
+			s.adopt(proxy, criteria)
+		}
+		logger.Info("Provider %s: allocated %d proxies (country %q, tags %v)", s.name, len(allocated), target.Country, target.Tags)
+	}
+}
+
+// adopt ghi nhận proxy do nhà cung cấp cấp, gắn tên nhà cung cấp cùng quốc gia và nhãn đã yêu cầu
+// khi phản hồi không có các thông tin đó
+func (s *ProviderSync) adopt(proxy *Proxy, criteria AllocationCriteria) {
+	if proxy.Provider == "" {
+		proxy.Provider = s.name
+	}
+	if proxy.Country == "" && criteria.Country != "" {
+		proxy.Country = strings.ToUpper(criteria.Country)
+		proxy.declaredCountry = proxy.Country
+	}
+	for _, tag := range criteria.Tags {
+		if !containsFold(proxy.Tags, tag) {
+			proxy.Tags = append(proxy.Tags, tag)
+		}
+	}
+	s.owned[proxy.URL] = proxy
+	s.changed = true
+}
+
+// Refresh yêu cầu đối soát ngay, không chờ tới chu kỳ tiếp theo
+func (s *ProviderSync) Refresh() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Stop dừng đối soát định kỳ, các proxy đã cấp được giữ nguyên
+func (s *ProviderSync) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
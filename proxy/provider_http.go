@@ -0,0 +1,319 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// maxProviderResponse giới hạn kích thước phản hồi từ API nhà cung cấp
+const maxProviderResponse = 8 << 20
+
+// HTTPProviderEndpoint mô tả một lời gọi API. Path và Body là text/template với các trường
+// .Count, .Country, .Tags (khi xin cấp) và .ID, .URL, .Host, .Port, .Username (proxy được trả lại hay
+// thay thế), cùng các hàm join, json và query.
+type HTTPProviderEndpoint struct {
+	// Method mặc định GET khi không có body, POST khi có body
+	Method string `json:"method,omitempty"`
+	// Path đường dẫn nối vào base_url, có thể kèm query
+	Path string `json:"path"`
+	// Body nội dung gửi đi dạng JSON
+	Body string `json:"body,omitempty"`
+}
+
+// HTTPProviderConfig cấu hình adapter cho API nhà cung cấp trả về JSON
+type HTTPProviderConfig struct {
+	BaseURL string `json:"base_url"`
+	// Headers header gửi kèm mọi request, ví dụ Authorization
+	Headers map[string]string `json:"headers,omitempty"`
+	// Timeout thời gian chờ tối đa mỗi lời gọi, mặc định 30s
+	Timeout string `json:"timeout,omitempty"`
+	// Type loại proxy cho các proxy không khai báo scheme, mặc định http
+	Type string `json:"type,omitempty"`
+	// ResultPath đường dẫn tới danh sách proxy trong phản hồi, các khóa cách nhau bởi dấu chấm
+	// (ví dụ "result.items"). Bỏ trống thì phản hồi được đọc như danh sách proxy dạng json.
+	ResultPath string `json:"result_path,omitempty"`
+
+	// Các endpoint không khai báo được coi là không hỗ trợ
+	List     *HTTPProviderEndpoint `json:"list,omitempty"`
+	Allocate *HTTPProviderEndpoint `json:"allocate,omitempty"`
+	Release  *HTTPProviderEndpoint `json:"release,omitempty"`
+	Replace  *HTTPProviderEndpoint `json:"replace,omitempty"`
+}
+
+// providerRequest là dữ liệu cho template của endpoint
+type providerRequest struct {
+	Count    int
+	Country  string
+	Tags     []string
+	ID       string
+	URL      string
+	Host     string
+	Port     string
+	Username string
+}
+
+// httpEndpoint là endpoint đã biên dịch template
+type httpEndpoint struct {
+	method string
+	path   *template.Template
+	body   *template.Template
+}
+
+// HTTPProvider là Provider gọi API HTTP trả về JSON theo cấu hình
+type HTTPProvider struct {
+	cfg         HTTPProviderConfig
+	client      *http.Client
+	defaultType ProxyType
+	endpoints   map[string]*httpEndpoint
+}
+
+var providerTemplateFuncs = template.FuncMap{
+	"join":  strings.Join,
+	"query": url.QueryEscape,
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+}
+
+// NewHTTPProvider tạo adapter HTTP-JSON, biên dịch template của các endpoint
+func NewHTTPProvider(cfg HTTPProviderConfig) (*HTTPProvider, error) {
+	// base_url và header có thể chứa API key dạng tham chiếu bí mật
+	baseURL, err := ResolveSecrets(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("base_url: %v", err)
+	}
+	headers, err := resolveHeaders(cfg.Headers)
+	if err != nil {
+		return nil, err
+	}
+	cfg.BaseURL, cfg.Headers = baseURL, headers
+
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+		return nil, fmt.Errorf("invalid base_url")
+	}
+
+	defaultType := ProxyTypeHTTP
+	if cfg.Type != "" {
+		var ok bool
+		if defaultType, ok = proxySchemes[cfg.Type]; !ok {
+			return nil, fmt.Errorf("unsupported type %q", cfg.Type)
+		}
+	}
+
+	timeout, err := parseSubscriptionDuration(cfg.Timeout, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timeout: %v", err)
+	}
+
+	p := &HTTPProvider{
+		cfg:         cfg,
+		client:      &http.Client{Timeout: timeout},
+		defaultType: defaultType,
+		endpoints:   make(map[string]*httpEndpoint),
+	}
+	for name, endpoint := range map[string]*HTTPProviderEndpoint{
+		"list":     cfg.List,
+		"allocate": cfg.Allocate,
+		"release":  cfg.Release,
+		"replace":  cfg.Replace,
+	} {
+		if endpoint == nil {
+			continue
+		}
+		compiled, err := compileEndpoint(name, *endpoint)
+		if err != nil {
+			return nil, err
+		}
+		p.endpoints[name] = compiled
+	}
+	return p, nil
+}
+
+func compileEndpoint(name string, endpoint HTTPProviderEndpoint) (*httpEndpoint, error) {
+	compiled := &httpEndpoint{method: strings.ToUpper(endpoint.Method)}
+
+	var err error
+	if compiled.path, err = template.New(name).Funcs(providerTemplateFuncs).Parse(endpoint.Path); err != nil {
+		return nil, fmt.Errorf("invalid %s path: %v", name, err)
+	}
+	if endpoint.Body != "" {
+		if compiled.body, err = template.New(name).Funcs(providerTemplateFuncs).Parse(endpoint.Body); err != nil {
+			return nil, fmt.Errorf("invalid %s body: %v", name, err)
+		}
+	}
+
+	if compiled.method == "" {
+		compiled.method = http.MethodGet
+		if compiled.body != nil {
+			compiled.method = http.MethodPost
+		}
+	}
+	return compiled, nil
+}
+
+// List gọi endpoint list
+func (p *HTTPProvider) List(ctx context.Context) ([]*Proxy, error) {
+	data, err := p.call(ctx, "list", providerRequest{})
+	if err != nil {
+		return nil, err
+	}
+	return p.parseProxies(data)
+}
+
+// Allocate gọi endpoint allocate
+func (p *HTTPProvider) Allocate(ctx context.Context, count int, criteria AllocationCriteria) ([]*Proxy, error) {
+	data, err := p.call(ctx, "allocate", providerRequest{Count: count, Country: criteria.Country, Tags: criteria.Tags})
+	if err != nil {
+		return nil, err
+	}
+	return p.parseProxies(data)
+}
+
+// Release gọi endpoint release, phản hồi 2xx là thành công
+func (p *HTTPProvider) Release(ctx context.Context, proxy *Proxy) error {
+	_, err := p.call(ctx, "release", proxyRequest(proxy))
+	return err
+}
+
+// Replace gọi endpoint replace, proxy đầu tiên trong phản hồi là proxy thay thế
+func (p *HTTPProvider) Replace(ctx context.Context, proxy *Proxy) (*Proxy, error) {
+	data, err := p.call(ctx, "replace", proxyRequest(proxy))
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := p.parseProxies(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("replace response has no proxy")
+	}
+	return proxies[0], nil
+}
+
+func proxyRequest(proxy *Proxy) providerRequest {
+	host, port, _ := net.SplitHostPort(proxyHostPort(proxy.URL))
+	return providerRequest{
+		ID:       proxy.ProviderID,
+		URL:      proxy.URL,
+		Host:     host,
+		Port:     port,
+		Username: proxy.Username,
+	}
+}
+
+// proxyHostPort bỏ scheme khỏi URL của proxy
+func proxyHostPort(proxyURL string) string {
+	if i := strings.Index(proxyURL, "://"); i >= 0 {
+		return proxyURL[i+3:]
+	}
+	return proxyURL
+}
+
+// call thực hiện lời gọi tới endpoint và trả về nội dung phản hồi
+func (p *HTTPProvider) call(ctx context.Context, name string, data providerRequest) ([]byte, error) {
+	endpoint, ok := p.endpoints[name]
+	if !ok {
+		return nil, ErrNotSupported
+	}
+
+	var path bytes.Buffer
+	if err := endpoint.path.Execute(&path, data); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	var body io.Reader
+	if endpoint.body != nil {
+		var buf bytes.Buffer
+		if err := endpoint.body.Execute(&buf, data); err != nil {
+			return nil, fmt.Errorf("%s: %v", name, err)
+		}
+		body = &buf
+	}
+
+	req, err := http.NewRequestWithContext(ctx, endpoint.method, strings.TrimRight(p.cfg.BaseURL, "/")+path.String(), body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	for header, value := range p.cfg.Headers {
+		req.Header.Set(header, value)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		// Bỏ URL khỏi lỗi vì query có thể chứa token
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, maxProviderResponse))
+	if err != nil {
+		return nil, fmt.Errorf("%s: failed to read response: %v", name, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: status %d", name, resp.StatusCode)
+	}
+	return respBody, nil
+}
+
+// parseProxies đọc danh sách proxy tại result_path, một object đơn lẻ được coi là danh sách một phần tử
+func (p *HTTPProvider) parseProxies(data []byte) ([]*Proxy, error) {
+	if p.cfg.ResultPath != "" {
+		var doc interface{}
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON response: %v", err)
+		}
+		for _, key := range strings.Split(p.cfg.ResultPath, ".") {
+			object, ok := doc.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("result_path %q not found in response", p.cfg.ResultPath)
+			}
+			if doc, ok = object[key]; !ok {
+				return nil, fmt.Errorf("result_path %q not found in response", p.cfg.ResultPath)
+			}
+		}
+		if _, ok := doc.([]interface{}); !ok {
+			doc = []interface{}{doc}
+		}
+		data, _ = json.Marshal(doc)
+	} else if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' && !hasProxyListKey(trimmed) {
+		data = append(append([]byte{'['}, trimmed...), ']')
+	}
+
+	proxies, lineErrors, err := parseProxyJSON(data, p.defaultType)
+	if err != nil {
+		return nil, err
+	}
+	for _, lineErr := range lineErrors {
+		logger.Warn("Provider response has invalid proxy at %v", lineErr)
+	}
+	return proxies, nil
+}
+
+// hasProxyListKey cho biết object có danh sách proxy trong "proxies" hay "data" như parseProxyJSON chấp nhận
+func hasProxyListKey(data []byte) bool {
+	var wrapped map[string]json.RawMessage
+	if err := json.Unmarshal(data, &wrapped); err != nil {
+		return false
+	}
+	_, proxies := wrapped["proxies"]
+	_, list := wrapped["data"]
+	return proxies || list
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"reflect"
+	"sync"
+	"time"
+
+	"proxy/utils"
+)
+
+// maxReloadEvents số sự kiện reload gần nhất được giữ lại cho API
+const maxReloadEvents = 20
+
+// ReloadEvent tóm tắt kết quả một lần tải (lại) danh sách proxy từ một nguồn
+type ReloadEvent struct {
+	Source    string    `json:"source"`
+	Time      time.Time `json:"time"`
+	Added     []string  `json:"added"`
+	Removed   []string  `json:"removed"`
+	Updated   int       `json:"updated"`   // Proxy giữ nguyên URL nhưng đổi thông tin đăng nhập, loại hoặc metadata
+	Unchanged int       `json:"unchanged"` // Proxy giữ nguyên, trạng thái và thống kê được giữ lại
+	Invalid   int       `json:"invalid"`   // Số dòng không hợp lệ
+}
+
+// reloadLog lưu các sự kiện reload gần nhất và các hàm nhận sự kiện
+type reloadLog struct {
+	mu     sync.Mutex
+	events []ReloadEvent
+	hooks  []func(ReloadEvent)
+}
+
+// OnReload đăng ký hàm được gọi sau mỗi lần tải lại danh sách proxy của pool
+func (pm *ProxyManager) OnReload(fn func(ReloadEvent)) {
+	pm.reloads.mu.Lock()
+	defer pm.reloads.mu.Unlock()
+	pm.reloads.hooks = append(pm.reloads.hooks, fn)
+}
+
+// Reloads trả về các sự kiện reload gần nhất, mới nhất đứng cuối
+func (pm *ProxyManager) Reloads() []ReloadEvent {
+	pm.reloads.mu.Lock()
+	defer pm.reloads.mu.Unlock()
+	return append([]ReloadEvent{}, pm.reloads.events...)
+}
+
+// ReloadSource cập nhật các proxy thuộc nguồn source theo danh sách mới: thêm proxy mới, retire và bỏ
+// khỏi pool các proxy không còn nguồn nào liệt kê (tunnel đang mở vẫn chạy tới khi kết thúc), giữ nguyên
+// trạng thái breaker và thống kê của proxy không đổi. invalid là số dòng lỗi để ghi vào sự kiện.
+func (pm *ProxyManager) ReloadSource(source string, proxies []*Proxy, invalid int) ReloadEvent {
+	event := ReloadEvent{
+		Source:  source,
+		Time:    time.Now(),
+		Added:   []string{},
+		Removed: []string{},
+		Invalid: invalid,
+	}
+
+	pm.mu.Lock()
+	existing := make(map[string]*Proxy, len(pm.proxies))
+	for _, proxy := range pm.proxies {
+		existing[proxy.URL] = proxy
+	}
+
+	// Password của proxy trong pool được che trong log tới khi proxy bị bỏ hoặc đổi password
+	var retained, released []string
+	listed := make(map[string]bool, len(proxies))
+	for _, proxy := range proxies {
+		listed[proxy.URL] = true
+
+		current, ok := existing[proxy.URL]
+		if !ok {
+			proxy.sources = map[string]bool{source: true}
+			retained = append(retained, proxy.Password)
+			pm.AddProxy(proxy)
+			existing[proxy.URL] = proxy
+			event.Added = append(event.Added, proxy.URL)
+			continue
+		}
+
+		if current.sources == nil {
+			current.sources = make(map[string]bool)
+		}
+		current.sources[source] = true
+		if current.Username != proxy.Username || current.Password != proxy.Password || current.Type != proxy.Type ||
+			!reflect.DeepEqual(current.ProxyMetadata, proxy.ProxyMetadata) ||
+			current.declaredCountry != proxy.declaredCountry || (proxy.Weight > 0 && current.Weight != proxy.Weight) {
+			current.Username = proxy.Username
+			if current.Password != proxy.Password {
+				retained = append(retained, proxy.Password)
+				released = append(released, current.Password)
+			}
+			current.Password = proxy.Password
+			current.usernameRef, current.passwordRef = proxy.usernameRef, proxy.passwordRef
+			current.Type = proxy.Type
+			current.ProxyMetadata = proxy.ProxyMetadata
+			if proxy.declaredCountry != "" {
+				current.Country = proxy.declaredCountry
+			}
+			current.declaredCountry = proxy.declaredCountry
+			if proxy.Weight > 0 {
+				current.Weight = proxy.Weight
+			}
+			pm.applyLimits(current)
+			event.Updated++
+		} else {
+			event.Unchanged++
+		}
+	}
+
+	kept := pm.proxies[:0]
+	for _, proxy := range pm.proxies {
+		if !proxy.sources[source] || listed[proxy.URL] {
+			kept = append(kept, proxy)
+			continue
+		}
+		delete(proxy.sources, source)
+		if len(proxy.sources) > 0 {
+			kept = append(kept, proxy)
+			continue
+		}
+		// Proxy bị xóa khỏi danh sách: retire để nơi còn giữ nó không chọn lại, kết nối đang mở không bị đóng
+		proxy.State = BreakerRetired
+		delete(pm.used, proxy.URL)
+		released = append(released, proxy.Password)
+		event.Removed = append(event.Removed, proxy.URL)
+	}
+	for i := len(kept); i < len(pm.proxies); i++ {
+		pm.proxies[i] = nil
+	}
+	pm.proxies = kept
+	utils.RetainSecrets(retained)
+	utils.ReleaseSecrets(released)
+	pm.mu.Unlock()
+
+	pm.reloads.mu.Lock()
+	pm.reloads.events = append(pm.reloads.events, event)
+	if len(pm.reloads.events) > maxReloadEvents {
+		pm.reloads.events = pm.reloads.events[len(pm.reloads.events)-maxReloadEvents:]
+	}
+	hooks := append([]func(ReloadEvent){}, pm.reloads.hooks...)
+	pm.reloads.mu.Unlock()
+
+	logger.Info("Reloaded %s: %d added, %d removed, %d updated, %d unchanged, %d invalid",
+		source, len(event.Added), len(event.Removed), event.Updated, event.Unchanged, event.Invalid)
+	for _, hook := range hooks {
+		hook(event)
+	}
+	return event
+}
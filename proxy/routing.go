@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RouteAction định nghĩa cách xử lý kết nối khớp một luật định tuyến
+type RouteAction string
+
+const (
+	// RouteActionPool gửi qua pool có tên trong luật, để trống tên là pool mặc định
+	RouteActionPool RouteAction = "pool"
+	// RouteActionDirect kết nối thẳng tới đích, không qua proxy upstream
+	RouteActionDirect RouteAction = "direct"
+	// RouteActionReject từ chối kết nối
+	RouteActionReject RouteAction = "reject"
+)
+
+// RoutingRule là một luật định tuyến theo đích và client. Các điều kiện khác rỗng phải
+// cùng khớp; trong mỗi điều kiện chỉ cần khớp một giá trị. Luật được xét theo thứ tự
+// khai báo, luật khớp đầu tiên được áp dụng.
+type RoutingRule struct {
+	// Hosts tên miền hoặc IP đích khớp chính xác
+	Hosts []string `json:"hosts,omitempty"`
+	// DomainSuffixes khớp tên miền và mọi tên miền con, ví dụ "example.com"
+	DomainSuffixes []string `json:"domain_suffixes,omitempty"`
+	// HostRegex biểu thức chính quy áp dụng lên host đích
+	HostRegex string `json:"host_regex,omitempty"`
+	// Ports cổng đích
+	Ports []int `json:"ports,omitempty"`
+	// Clients địa chỉ IP hoặc dải CIDR của client
+	Clients []string `json:"clients,omitempty"`
+
+	// Action mặc định là "pool" khi có Pool
+	Action RouteAction `json:"action,omitempty"`
+	Pool   string      `json:"pool,omitempty"`
+}
+
+// routeRule là RoutingRule đã được biên dịch
+type routeRule struct {
+	RoutingRule
+	regex   *regexp.Regexp
+	clients []*net.IPNet
+	pm      *ProxyManager
+}
+
+// router giữ bảng luật định tuyến đã biên dịch
+type router struct {
+	rules []*routeRule
+}
+
+// routes là bảng luật đang dùng, nil khi không có luật; được thay khi tải lại cấu hình
+var routes atomic.Pointer[router]
+
+// ConfigureRouting biên dịch bảng luật định tuyến, pools ánh xạ tên pool trong luật tới manager
+func ConfigureRouting(rules []RoutingRule, pools map[string]*ProxyManager) error {
+	r, err := compileRoutes(rules, pools)
+	if err != nil {
+		return err
+	}
+	setRoutes(r)
+	return nil
+}
+
+// compileRoutes biên dịch bảng luật mà chưa dùng nó, nil khi không có luật
+func compileRoutes(rules []RoutingRule, pools map[string]*ProxyManager) (*router, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	r := &router{}
+	for i, rule := range rules {
+		compiled, err := compileRouteRule(rule, pools)
+		if err != nil {
+			return nil, fmt.Errorf("routing rule %d: %v", i+1, err)
+		}
+		r.rules = append(r.rules, compiled)
+	}
+	return r, nil
+}
+
+// setRoutes dùng bảng luật r cho các kết nối mới, nil để tắt định tuyến
+func setRoutes(r *router) {
+	routes.Store(r)
+	if r != nil {
+		logger.Info("Routing enabled with %d rules", len(r.rules))
+	}
+}
+
+func compileRouteRule(rule RoutingRule, pools map[string]*ProxyManager) (*routeRule, error) {
+	compiled := &routeRule{RoutingRule: rule}
+
+	if compiled.Action == "" {
+		compiled.Action = RouteActionPool
+	}
+	switch compiled.Action {
+	case RouteActionPool:
+		if rule.Pool != "" {
+			pm, ok := pools[rule.Pool]
+			if !ok {
+				return nil, fmt.Errorf("unknown pool %q", rule.Pool)
+			}
+			compiled.pm = pm
+		}
+	case RouteActionDirect, RouteActionReject:
+	default:
+		return nil, fmt.Errorf("unknown action %q", rule.Action)
+	}
+
+	if rule.HostRegex != "" {
+		regex, err := regexp.Compile(rule.HostRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host_regex: %v", err)
+		}
+		compiled.regex = regex
+	}
+
+	for _, client := range rule.Clients {
+		if !strings.Contains(client, "/") {
+			if ip := net.ParseIP(client); ip != nil && ip.To4() != nil {
+				client += "/32"
+			} else {
+				client += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(client)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client %q: %v", client, err)
+		}
+		compiled.clients = append(compiled.clients, network)
+	}
+
+	return compiled, nil
+}
+
+// matches kiểm tra luật có khớp host, port đích và IP client
+func (r *routeRule) matches(host string, port int, clientIP net.IP) bool {
+	if len(r.Hosts) > 0 || len(r.DomainSuffixes) > 0 || r.regex != nil {
+		if !r.matchesHost(host) {
+			return false
+		}
+	}
+
+	if len(r.Ports) > 0 {
+		found := false
+		for _, p := range r.Ports {
+			if p == port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(r.clients) > 0 {
+		if clientIP == nil {
+			return false
+		}
+		found := false
+		for _, network := range r.clients {
+			if network.Contains(clientIP) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesHost khớp khi host thỏa một trong các điều kiện hosts, domain_suffixes, host_regex
+func (r *routeRule) matchesHost(host string) bool {
+	for _, h := range r.Hosts {
+		if strings.EqualFold(h, host) {
+			return true
+		}
+	}
+	for _, suffix := range r.DomainSuffixes {
+		suffix = strings.ToLower(strings.TrimPrefix(suffix, "."))
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return true
+		}
+	}
+	return r.regex != nil && r.regex.MatchString(host)
+}
+
+// route chọn cách xử lý kết nối tới hostPort từ địa chỉ client (host:port). Trả về manager sẽ dùng khi
+// action là pool; không có luật nào khớp thì dùng pm của listener.
+func route(pm *ProxyManager, hostPort string, client string) (RouteAction, *ProxyManager) {
+	r := routes.Load()
+	if r == nil {
+		return RouteActionPool, pm
+	}
+
+	host, portStr, err := net.SplitHostPort(hostPort)
+	if err != nil {
+		host = hostPort
+	}
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	port, _ := strconv.Atoi(portStr)
+
+	var clientIP net.IP
+	if clientHost, _, err := net.SplitHostPort(client); err == nil {
+		clientIP = net.ParseIP(clientHost)
+	}
+
+	for _, rule := range r.rules {
+		if !rule.matches(host, port, clientIP) {
+			continue
+		}
+
+		logger.Debug("Route %s from %s: %s %s", hostPort, client, rule.Action, rule.Pool)
+		if rule.Action == RouteActionPool && rule.pm != nil {
+			return RouteActionPool, rule.pm
+		}
+		return rule.Action, pm
+	}
+
+	return RouteActionPool, pm
+}
+
+// errRouteRejected là lỗi khi luật định tuyến chặn request
+var errRouteRejected = errors.New("blocked by routing rules")
+
+// roundTripRouted gửi request theo luật định tuyến của đích và client: thẳng tới đích với direct,
+// qua pool của luật với pool. Trả về errRouteRejected khi luật chặn request.
+func roundTripRouted(pm *ProxyManager, req *http.Request, client string) (*http.Response, error) {
+	action, routed := route(pm, requestHostPort(req.URL.String(), req.Host), client)
+	switch action {
+	case RouteActionReject:
+		logger.Info("Request to %s rejected by routing rules", req.URL.Host)
+		return nil, errRouteRejected
+	case RouteActionDirect:
+		logger.Info("Sending request directly to %s", req.URL.Host)
+		return directTransport.RoundTrip(req)
+	}
+	return (&ProxyTransport{proxyManager: routed}).RoundTrip(req, nil)
+}
+
+// directTransport gửi request HTTP thẳng tới đích, bỏ qua biến môi trường proxy
+var directTransport = &http.Transport{
+	Proxy:               nil,
+	DialContext:         (&net.Dialer{Timeout: 10 * time.Second}).DialContext,
+	TLSHandshakeTimeout: 10 * time.Second,
+}
+
+// dialDirect kết nối thẳng tới đích cho các route direct
+func dialDirect(hostPort string) (net.Conn, error) {
+	return net.DialTimeout("tcp", hostPort, 10*time.Second)
+}
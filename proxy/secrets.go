@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"proxy/utils"
+)
+
+// encryptedSecretsPrefix đánh dấu file bí mật đã mã hóa, theo sau là base64(nonce || ciphertext)
+const encryptedSecretsPrefix = "proxy-secrets:v1:"
+
+// secretRefPattern tham chiếu ${NAME} tới biến môi trường hoặc ${secrets.NAME} tới file bí mật
+var secretRefPattern = regexp.MustCompile(`\$\{(secrets\.)?([A-Za-z_][A-Za-z0-9_-]*)\}`)
+
+// SecretsConfig cấu hình file bí mật cho các tham chiếu ${secrets.NAME} trong danh sách proxy và cấu hình
+type SecretsConfig struct {
+	// File JSON object tên → giá trị
+	File string `json:"file"`
+	// KeyFile file chứa khóa AES-256 dạng hex; khai báo thì File phải được mã hóa bằng "secrets encrypt"
+	KeyFile string `json:"key_file,omitempty"`
+}
+
+var secretStore struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+// ConfigureSecrets đọc (và giải mã) file bí mật, nil để bỏ cấu hình
+func ConfigureSecrets(cfg *SecretsConfig) error {
+	values, err := loadSecrets(cfg)
+	if err != nil {
+		return err
+	}
+	setSecrets(values)
+	return nil
+}
+
+// loadSecrets đọc (và giải mã) file bí mật mà chưa dùng các giá trị trong đó
+func loadSecrets(cfg *SecretsConfig) (map[string]string, error) {
+	values := make(map[string]string)
+	if cfg == nil || cfg.File == "" {
+		return values, nil
+	}
+
+	data, err := os.ReadFile(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %v", err)
+	}
+
+	if cfg.KeyFile != "" {
+		key, err := ReadKeyFile(cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = DecryptSecrets(data, key); err != nil {
+			return nil, fmt.Errorf("secrets file %s: %v", cfg.File, err)
+		}
+	} else if bytes.HasPrefix(data, []byte(encryptedSecretsPrefix)) {
+		return nil, fmt.Errorf("secrets file %s is encrypted but no key_file is configured", cfg.File)
+	}
+
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("secrets file %s must be a JSON object of strings: %v", cfg.File, err)
+	}
+	return values, nil
+}
+
+// setSecrets dùng values cho các tham chiếu ${secrets.NAME} và che chúng trong log
+func setSecrets(values map[string]string) {
+	for _, value := range values {
+		utils.RegisterSecret(value)
+	}
+
+	secretStore.mu.Lock()
+	secretStore.values = values
+	secretStore.mu.Unlock()
+}
+
+// ResolveSecrets thay các tham chiếu ${NAME} (biến môi trường) và ${secrets.NAME} (file bí mật) trong value.
+// Giá trị thay vào được đăng ký để che trong log; lỗi chỉ nêu tên tham chiếu.
+func ResolveSecrets(value string) (string, error) {
+	if !strings.Contains(value, "${") {
+		return value, nil
+	}
+
+	var resolveErr error
+	resolved := secretRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		match := secretRefPattern.FindStringSubmatch(ref)
+		name := match[2]
+
+		var secret string
+		var ok bool
+		if match[1] != "" {
+			secretStore.mu.RLock()
+			secret, ok = secretStore.values[name]
+			secretStore.mu.RUnlock()
+		} else {
+			secret, ok = os.LookupEnv(name)
+		}
+		if !ok {
+			if resolveErr == nil {
+				resolveErr = fmt.Errorf("unresolved secret reference %s", ref)
+			}
+			return ref
+		}
+		utils.RegisterSecret(secret)
+		return secret
+	})
+	return resolved, resolveErr
+}
+
+// resolveCredentials thay tham chiếu bí mật trong username và password. Password được che trong log từ
+// khi proxy vào pool tới khi bị bỏ khỏi pool (xem ReloadSource).
+func (p *Proxy) resolveCredentials() error {
+	p.usernameRef, p.passwordRef = p.Username, p.Password
+	var err error
+	if p.Username, err = ResolveSecrets(p.Username); err != nil {
+		return err
+	}
+	if p.Password, err = ResolveSecrets(p.Password); err != nil {
+		return err
+	}
+	return nil
+}
+
+// resolveHeaders trả về bản sao headers đã thay tham chiếu bí mật
+func resolveHeaders(headers map[string]string) (map[string]string, error) {
+	resolved := make(map[string]string, len(headers))
+	for name, value := range headers {
+		var err error
+		if resolved[name], err = ResolveSecrets(value); err != nil {
+			return nil, fmt.Errorf("header %s: %v", name, err)
+		}
+	}
+	return resolved, nil
+}
+
+// GenerateKey tạo khóa AES-256 ngẫu nhiên dạng hex cho key_file
+func GenerateKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(key), nil
+}
+
+// ReadKeyFile đọc khóa AES-256 dạng hex, cảnh báo nếu file đọc được bởi người dùng khác
+func ReadKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("key file %s must contain a 32-byte key in hex", path)
+	}
+	if info, err := os.Stat(path); err == nil && info.Mode().Perm()&0077 != 0 {
+		logger.Warn("Key file %s is accessible by other users, consider chmod 600", path)
+	}
+	return key, nil
+}
+
+// EncryptSecrets mã hóa nội dung file bí mật bằng AES-256-GCM
+func EncryptSecrets(plaintext, key []byte) ([]byte, error) {
+	gcm, err := newSecretsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, nil)
+	return []byte(encryptedSecretsPrefix + base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+// DecryptSecrets giải mã nội dung tạo bởi EncryptSecrets
+func DecryptSecrets(data, key []byte) ([]byte, error) {
+	encoded := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(encoded, encryptedSecretsPrefix) {
+		return nil, fmt.Errorf("not an encrypted secrets file")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(encoded, encryptedSecretsPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypted data: %v", err)
+	}
+
+	gcm, err := newSecretsCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted data")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed, wrong key or corrupted file")
+	}
+	return plaintext, nil
+}
+
+func newSecretsCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
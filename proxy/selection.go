@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"strings"
+	"time"
+)
+
+// SetStrategy đặt strategy mặc định của pool, nil để dùng lại cách chọn cũ
+func (pm *ProxyManager) SetStrategy(strategy Strategy) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.strategy = strategy
+}
+
+// SetWeights đặt trọng số theo địa chỉ proxy ("host:port") cho strategy weighted-random
+func (pm *ProxyManager) SetWeights(weights map[string]int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.weights = weights
+	for _, proxy := range pm.proxies {
+		if weight, ok := weights[proxyAddress(proxy.URL)]; ok {
+			proxy.Weight = weight
+		}
+	}
+}
+
+// WithStrategy trả về ProxyManager dùng chung pool nhưng chọn proxy bằng strategy riêng,
+// dùng cho listener cấu hình strategy khác với pool
+func (pm *ProxyManager) WithStrategy(strategy Strategy) *ProxyManager {
+	view := *pm
+	view.listenerStrategy = strategy
+	return &view
+}
+
+// selectionStrategy trả về strategy của listener nếu có, nếu không thì strategy của pool
+func (pm *ProxyManager) selectionStrategy() Strategy {
+	if pm.listenerStrategy != nil {
+		return pm.listenerStrategy
+	}
+	return pm.strategy
+}
+
+// proxyAddress bỏ scheme và mã session gateway (sau dấu #) khỏi URL proxy, dùng làm địa chỉ kết nối
+// và khóa cấu hình theo proxy
+func proxyAddress(proxyURL string) string {
+	if i := strings.Index(proxyURL, "#"); i >= 0 {
+		proxyURL = proxyURL[:i]
+	}
+	if i := strings.Index(proxyURL, "://"); i >= 0 {
+		return proxyURL[i+3:]
+	}
+	return proxyURL
+}
+
+// candidates trả về các proxy có breaker cho phép chọn phù hợp với bộ lọc và chưa chạm giới hạn,
+// kèm số proxy phù hợp nhưng đang bão hòa. Gọi khi đang giữ pm.mu.
+func (pm *ProxyManager) candidates(excludeURL string, selector ProxySelector) ([]*Proxy, int) {
+	now := time.Now()
+	var eligibleProxies []*Proxy
+	saturated := 0
+	for _, proxy := range pm.proxies {
+		if proxy.URL == excludeURL || (selector != nil && !selector(proxy)) || !pm.filter.matches(proxy) || proxy.expired(now) || proxy.bannedFor(pm.destination, now) || !pm.isSelectable(proxy, now) {
+			continue
+		}
+		if proxy.saturated(now) {
+			saturated++
+			continue
+		}
+		eligibleProxies = append(eligibleProxies, proxy)
+	}
+	return eligibleProxies, saturated
+}
+
+// markUsed cập nhật thời gian sử dụng của proxy vừa được chọn, gọi khi đang giữ pm.mu
+func (pm *ProxyManager) markUsed(proxy *Proxy) {
+	now := time.Now()
+	proxy.LastUsed = now
+	pm.used[proxy.URL] = now
+	pm.onSelected(proxy, now)
+	for _, l := range proxy.currentLimiters() {
+		l.reserve(now)
+	}
+}
+
+// GetRandomProxy trả về một proxy ngẫu nhiên (hoặc theo strategy đã cấu hình)
+func (pm *ProxyManager) GetRandomProxy() *Proxy {
+	selectedProxy := pm.GetRandomProxyWithFilter(nil)
+	if selectedProxy == nil {
+		logger.Error("No working proxies available")
+	}
+	return selectedProxy
+}
+
+// GetRandomProxyWithFilter trả về một proxy phù hợp với bộ lọc, chọn theo strategy
+// đã cấu hình hoặc ngẫu nhiên nếu chưa cấu hình
+func (pm *ProxyManager) GetRandomProxyWithFilter(selector ProxySelector) *Proxy {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	eligibleProxies := pm.waitCandidates("", selector)
+	if len(eligibleProxies) == 0 {
+		return nil
+	}
+
+	var selectedProxy *Proxy
+	if strategy := pm.selectionStrategy(); strategy != nil {
+		selectedProxy = strategy.Select(eligibleProxies)
+		logger.Info("Selected proxy by %s: %s", strategy.Name(), selectedProxy.URL)
+	} else {
+		selectedProxy = eligibleProxies[pm.rand.Intn(len(eligibleProxies))]
+		logger.Info("Selected random proxy: %s", selectedProxy.URL)
+	}
+
+	pm.markUsed(selectedProxy)
+	return selectedProxy
+}
+
+// GetNextWorkingProxy returns the next working proxy
+func (pm *ProxyManager) GetNextWorkingProxy(excludeURL string) *Proxy {
+	return pm.GetNextWorkingProxyWithFilter(excludeURL, nil)
+}
+
+// GetNextWorkingProxyWithFilter trả về proxy tiếp theo phù hợp với bộ lọc để thử lại,
+// chọn theo strategy đã cấu hình hoặc proxy đã lâu không sử dụng nếu chưa cấu hình
+func (pm *ProxyManager) GetNextWorkingProxyWithFilter(excludeURL string, selector ProxySelector) *Proxy {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	eligibleProxies := pm.waitCandidates(excludeURL, selector)
+	if len(eligibleProxies) == 0 {
+		return nil
+	}
+
+	var selectedProxy *Proxy
+	if strategy := pm.selectionStrategy(); strategy != nil {
+		selectedProxy = strategy.Select(eligibleProxies)
+	} else {
+		// Tìm proxy chưa được sử dụng hoặc đã lâu không sử dụng
+		var oldestUsedTime time.Time
+		for _, proxy := range eligibleProxies {
+			lastUsed, exists := pm.used[proxy.URL]
+			if !exists {
+				// Nếu proxy chưa từng được sử dụng, chọn ngay lập tức
+				selectedProxy = proxy
+				break
+			}
+			if selectedProxy == nil || lastUsed.Before(oldestUsedTime) {
+				oldestUsedTime = lastUsed
+				selectedProxy = proxy
+			}
+		}
+	}
+
+	pm.markUsed(selectedProxy)
+	logger.Info("Selected next proxy: %s", selectedProxy.URL)
+	return selectedProxy
+}
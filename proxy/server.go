@@ -3,13 +3,13 @@ package proxy
 import (
 	"bufio"
 	"bytes"
-	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
 	"net/url"
 	"strings"
 	"time"
@@ -39,6 +39,9 @@ func (t *ProxyTransport) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*h
 		logger.Header("  %s: %v", k, v)
 	}
 
+	// Bộ lọc GeoIP theo request từ các header X-Proxy-*
+	pm := t.proxyManager.WithFilter(requestFilterFromHeader(req.Header)).ForDestination(req.URL.Hostname())
+
 	// Track already tried proxies to avoid using them again in retries
 	triedProxies := make(map[string]bool)
 	var lastError error
@@ -49,14 +52,16 @@ func (t *ProxyTransport) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*h
 		// Get a proxy, excluding ones we've already tried
 		var proxy *Proxy
 		if retry == 0 {
-			proxy = t.proxyManager.GetRandomProxy()
+			proxy = pm.GetRandomProxy()
 		} else {
 			var excludeURL string
 			if lastProxy != nil {
 				excludeURL = lastProxy.URL
 			}
-			proxy = t.proxyManager.GetNextWorkingProxy(excludeURL)
-			logger.Info("Retry %d/%d with proxy %s", retry, t.proxyManager.maxRetries, proxy.URL)
+			proxy = pm.GetNextWorkingProxy(excludeURL)
+			if proxy != nil {
+				logger.Info("Retry %d/%d with proxy %s", retry, t.proxyManager.maxRetries, proxy.URL)
+			}
 		}
 
 		if proxy == nil {
@@ -69,6 +74,7 @@ func (t *ProxyTransport) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*h
 
 		// Skip if we've already tried this proxy
 		if triedProxies[proxy.URL] {
+			proxy.cancelReservation()
 			continue
 		}
 
@@ -80,7 +86,7 @@ func (t *ProxyTransport) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*h
 		var err error
 
 		// Parse proxy URL based on format
-		if strings.Contains(proxy.URL, "http://") {
+		if strings.Contains(proxy.URL, "://") {
 			// URL already has scheme
 			proxyURL, err = url.Parse(proxy.URL)
 		} else {
@@ -91,13 +97,15 @@ func (t *ProxyTransport) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*h
 		if err != nil {
 			logger.Error("Invalid proxy URL: %v", err)
 			lastError = err
+			proxy.cancelReservation()
 			t.proxyManager.MarkProxyFailed(proxy)
 			continue // Try next proxy
 		}
 
 		// Set proxy authentication in URL if credentials exist
-		if proxy.Username != "" && proxy.Password != "" {
-			proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+		username, password := proxy.credentials()
+		if username != "" && password != "" {
+			proxyURL.User = url.UserPassword(username, password)
 		}
 
 		// Create a new request to forward
@@ -111,9 +119,29 @@ func (t *ProxyTransport) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*h
 			forwardReq.URL.RawQuery = req.URL.RawQuery
 		}
 
-		// Add proxy authentication header
-		if proxy.Username != "" && proxy.Password != "" {
-			auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", proxy.Username, proxy.Password)))
+		// Body đã bị đọc ở lần thử trước, lấy lại bản mới nếu có thể
+		if retry > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				proxy.cancelReservation()
+				return nil, fmt.Errorf("failed to rewind request body: %v", err)
+			}
+			forwardReq.Body = body
+		}
+
+		// Apply configured header rules
+		t.proxyManager.mu.RLock()
+		t.proxyManager.headerRules.apply(forwardReq.Header)
+		t.proxyManager.mu.RUnlock()
+
+		// Đo thời gian kết nối, thời gian tới byte đầu tiên và lưu lượng gửi đi
+		forwardReq = forwardReq.WithContext(httptrace.WithClientTrace(forwardReq.Context(), proxy.clientTrace(forwardReq.ContentLength)))
+
+		// Proxy-Authorization chỉ gửi trong request tới proxy HTTP khi đích là http; với đích https header đi qua
+		// tunnel CONNECT tới origin, với proxy SOCKS header tới thẳng origin nên phải bỏ
+		forwardReq.Header.Del("Proxy-Authorization")
+		if httpOnlySelector(proxy) && forwardReq.URL.Scheme == "http" && username != "" && password != "" {
+			auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
 			forwardReq.Header.Set("Proxy-Authorization", "Basic "+auth)
 		}
 
@@ -132,78 +160,52 @@ func (t *ProxyTransport) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*h
 			ProxyConnectHeader: http.Header{},
 		}
 
-		// Add proxy authentication header if credentials exist
-		if proxy.Username != "" && proxy.Password != "" {
-			auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", proxy.Username, proxy.Password)))
+		// Xác thực với proxy HTTP khi mở tunnel CONNECT, proxy SOCKS xác thực bằng proxyURL.User
+		if httpOnlySelector(proxy) && username != "" && password != "" {
+			auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password)))
 			transport.ProxyConnectHeader.Set("Proxy-Authorization", "Basic "+auth)
 		}
+		if proxy.Type == ProxyTypeSOCKS4 {
+			// http.Transport không hỗ trợ SOCKS4 nên tự mở kết nối qua proxy
+			transport.Proxy = nil
+			transport.DialContext = socks4DialContext(proxy)
+		}
 
 		// Set a shorter timeout for faster failure detection
 		clientTimeout := 20 * time.Second
 
-		// Create a custom client for HTTP requests
-		if req.URL.Scheme == "http" {
-			client := &http.Client{
-				Transport: transport,
-				Timeout:   clientTimeout,
-			}
-
-			logger.Proxy("Forwarding HTTP request to: %s via proxy %s", forwardReq.URL.String(), proxyURL.String())
-			resp, err := client.Do(forwardReq)
-			if err != nil {
-				logger.Error("Error forwarding HTTP request: %v", err)
-				lastError = err
-				t.proxyManager.MarkProxyFailed(proxy)
-				continue // Try next proxy
-			}
-
-			// Success - return the response
-			return resp, nil
+		// Response của cả http và https (MITM) được trả về dạng stream, body không bị đọc vào bộ nhớ
+		client := &http.Client{
+			Transport: transport,
+			Timeout:   clientTimeout,
+			// Trả nguyên redirect về cho client thay vì tự đi theo
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
 		}
 
-		logger.Proxy("Forwarding request to: %s via proxy %s", forwardReq.URL.String(), proxyURL.String())
-
-		// Set custom timeout for the request context
-		ctx, cancel := context.WithTimeout(forwardReq.Context(), clientTimeout)
-		forwardReq = forwardReq.WithContext(ctx)
-		defer cancel()
-
-		resp, err := transport.RoundTrip(forwardReq)
+		logger.Proxy("Forwarding request to: %s via proxy %s", forwardReq.URL.String(), proxyURL.Redacted())
+		held := proxy.acquire()
+		resp, err := client.Do(forwardReq)
 		if err != nil {
+			proxy.release(held)
 			logger.Error("Error forwarding request: %v", err)
 			lastError = err
 			t.proxyManager.MarkProxyFailed(proxy)
 			continue // Try next proxy
 		}
 
-		// Create a new response with the same status and headers
-		newResp := &http.Response{
-			Status:     resp.Status,
-			StatusCode: resp.StatusCode,
-			Proto:      resp.Proto,
-			ProtoMajor: resp.ProtoMajor,
-			ProtoMinor: resp.ProtoMinor,
-			Header:     resp.Header,
+		resp.Body = proxy.trackBody(resp.Body, held)
+		if t.retryOnStatus(resp, proxy, forwardReq.URL.Hostname(), retry, &lastError) {
+			continue // Try next proxy
 		}
 
-		// Copy the body
-		if resp.Body != nil {
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				logger.Error("Error reading response body: %v", err)
-				lastError = err
-				t.proxyManager.MarkProxyFailed(proxy)
-				continue // Try next proxy
-			}
-			resp.Body.Close()
-
-			newResp.Body = io.NopCloser(strings.NewReader(string(body)))
-			newResp.ContentLength = int64(len(body))
-			logger.Response("Response body: %s", string(body))
+		// Success - return the response. Status thử lại ở lần thử cuối không tính là thành công
+		if !t.proxyManager.shouldRetryStatus(resp.StatusCode) {
+			t.proxyManager.MarkProxySuccess(proxy)
 		}
-
 		logger.EndRequest()
-		return newResp, nil
+		return resp, nil
 	}
 
 	// If we get here, all retries failed
@@ -211,6 +213,31 @@ func (t *ProxyTransport) RoundTrip(req *http.Request, ctx *goproxy.ProxyCtx) (*h
 		t.proxyManager.maxRetries, lastError)
 }
 
+// retryOnStatus checks whether the response status should trigger a retry with another proxy.
+// Khi ban theo đích được bật, proxy bị ban với host thay vì bị tính lỗi toàn cục.
+func (t *ProxyTransport) retryOnStatus(resp *http.Response, proxy *Proxy, host string, retry int, lastError *error) bool {
+	if !t.proxyManager.shouldRetryStatus(resp.StatusCode) {
+		return false
+	}
+
+	banned := t.proxyManager.banForStatus(proxy, host)
+	if retry >= t.proxyManager.maxRetries {
+		// Hết lượt thử: response vẫn được trả cho client nhưng vẫn tính là lỗi trong thống kê của proxy
+		if !banned {
+			t.proxyManager.MarkProxyFailed(proxy)
+		}
+		return false
+	}
+
+	logger.Error("Proxy %s returned status %d, retrying", proxy.URL, resp.StatusCode)
+	resp.Body.Close()
+	*lastError = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+	if !banned {
+		t.proxyManager.MarkProxyFailed(proxy)
+	}
+	return true
+}
+
 // handleConnect handles CONNECT requests
 func (t *ProxyTransport) handleConnect(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
 	logger.StartRequest()
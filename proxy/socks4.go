@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SOCKS4_VERSION     = 0x04
+	SOCKS4_CMD_CONNECT = 0x01
+	SOCKS4_GRANTED     = 0x5A
+)
+
+// socks4Connect gửi request CONNECT tới host:port qua kết nối tới proxy SOCKS4.
+// Dùng dạng SOCKS4a (proxy tự phân giải tên miền) khi scheme là socks4a hoặc host không phải IP,
+// với socks4 thì host được phân giải tại đây.
+func socks4Connect(proxyConn net.Conn, proxy *Proxy, host string, port uint16) error {
+	request := []byte{SOCKS4_VERSION, SOCKS4_CMD_CONNECT, byte(port >> 8), byte(port)}
+
+	var domain string
+	ip := net.ParseIP(host).To4()
+	if ip == nil && !strings.HasPrefix(strings.ToLower(proxy.URL), "socks4a://") {
+		addrs, err := net.DefaultResolver.LookupIP(context.Background(), "ip4", host)
+		if err != nil || len(addrs) == 0 {
+			return fmt.Errorf("failed to resolve %s for SOCKS4: %v", host, err)
+		}
+		ip = addrs[0].To4()
+	}
+	if ip == nil {
+		// SOCKS4a: IP 0.0.0.x báo cho proxy rằng tên miền nằm sau user ID
+		ip = net.IPv4(0, 0, 0, 1).To4()
+		domain = host
+	}
+	request = append(request, ip...)
+
+	// SOCKS4 chỉ có user ID, không có password
+	userID, _ := proxy.credentials()
+	request = append(request, []byte(userID)...)
+	request = append(request, 0x00)
+	if domain != "" {
+		request = append(request, []byte(domain)...)
+		request = append(request, 0x00)
+	}
+
+	if err := proxyConn.SetDeadline(time.Now().Add(10 * time.Second)); err != nil {
+		return fmt.Errorf("failed to set deadline: %v", err)
+	}
+	if _, err := proxyConn.Write(request); err != nil {
+		return fmt.Errorf("failed to send SOCKS4 request: %v", err)
+	}
+
+	// Phản hồi: VN | CD | DSTPORT | DSTIP
+	reply := make([]byte, 8)
+	if _, err := io.ReadFull(proxyConn, reply); err != nil {
+		return fmt.Errorf("failed to read SOCKS4 response: %v", err)
+	}
+	if reply[1] != SOCKS4_GRANTED {
+		return fmt.Errorf("SOCKS4 proxy rejected request: 0x%02x", reply[1])
+	}
+
+	if err := proxyConn.SetDeadline(time.Time{}); err != nil {
+		logger.Error("Failed to reset deadline: %v", err)
+	}
+	return nil
+}
+
+// socks4DialContext trả về DialContext cho http.Transport, mở kết nối tới đích qua proxy SOCKS4
+func socks4DialContext(proxy *Proxy) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		port, err := strconv.ParseUint(portStr, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port %q", portStr)
+		}
+
+		conn, err := dialProxy(proxy)
+		if err != nil {
+			return nil, err
+		}
+		if err := socks4Connect(conn, proxy, host, uint16(port)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+}
@@ -71,13 +71,13 @@ func handleSOCKS5(clientConn net.Conn, pm *ProxyManager) {
 
 	// Kiểm tra xem số lượng proxy SOCKS5 khả dụng
 	socks5Selector := func(p *Proxy) bool {
-		return p.Type == ProxyTypeSOCKS5
+		return p.Type == ProxyTypeSOCKS5 || p.Type == ProxyTypeSOCKS4
 	}
 
 	pm.mu.RLock()
 	var socks5Count int
 	for _, proxy := range pm.proxies {
-		if proxy.IsWorking && socks5Selector(proxy) {
+		if proxy.State == BreakerClosed && socks5Selector(proxy) {
 			socks5Count++
 		}
 	}
@@ -173,39 +173,31 @@ func handleSOCKS5(clientConn net.Conn, pm *ProxyManager) {
 	}
 	targetPort = binary.BigEndian.Uint16(portBytes)
 
-	targetAddr := fmt.Sprintf("%s:%d", targetHost, targetPort)
+	targetAddr := net.JoinHostPort(targetHost, fmt.Sprint(targetPort))
 	logger.Info("SOCKS5 target: %s", targetAddr)
 
-	// Chọn proxy SOCKS5 để sử dụng
+	// Định tuyến theo đích và client trước khi chọn proxy
+	action, routed := route(pm, targetAddr, clientConn.RemoteAddr().String())
+	switch action {
+	case RouteActionReject:
+		logger.Info("SOCKS5 connection to %s rejected by routing rules", targetAddr)
+		sendSocks5Error(clientConn, 0x02) // Connection not allowed by ruleset
+		return
+	case RouteActionDirect:
+		serveSOCKS5Direct(clientConn, targetAddr)
+		return
+	}
+	pm = routed.ForDestination(targetHost)
+
+	// Chọn proxy SOCKS để sử dụng, proxy SOCKS4 dùng được cho lệnh CONNECT
 	socks5Selector = func(p *Proxy) bool {
-		return p.Type == ProxyTypeSOCKS5
+		return p.Type == ProxyTypeSOCKS5 || p.Type == ProxyTypeSOCKS4
 	}
 
 	proxy := pm.GetRandomProxyWithFilter(socks5Selector)
 	if proxy == nil {
 		logger.Info("No available SOCKS5 proxies found, connecting directly to target")
-		// Kết nối trực tiếp đến đích nếu không có proxy nào có sẵn
-		targetConn, err := net.DialTimeout("tcp", targetAddr, 10*time.Second)
-		if err != nil {
-			logger.Error("Failed to connect directly to target: %v", err)
-			sendSocks5Error(clientConn, 0x04) // Host unreachable
-			return
-		}
-		defer targetConn.Close()
-
-		// Gửi phản hồi thành công về client
-		responseHeader := []byte{
-			SOCKS5_VERSION,
-			0x00, // Thành công
-			0x00, // Reserved
-			SOCKS5_ADDR_TYPE_IPV4,
-			0, 0, 0, 0, // Bất kỳ địa chỉ IP nào
-			0, 0, // Bất kỳ cổng nào
-		}
-		clientConn.Write(responseHeader)
-
-		// Chuyển tiếp dữ liệu qua lại giữa client và target
-		handleTLSOverSOCKS5(clientConn, "direct:"+targetAddr, targetConn)
+		serveSOCKS5Direct(clientConn, targetAddr)
 		return
 	}
 
@@ -215,6 +207,7 @@ func handleSOCKS5(clientConn net.Conn, pm *ProxyManager) {
 	proxyURL, err := url.Parse(proxy.URL)
 	if err != nil {
 		logger.Error("Failed to parse proxy URL: %v", err)
+		proxy.cancelReservation()
 		sendSocks5Error(clientConn, 0x01)
 		return
 	}
@@ -229,19 +222,37 @@ func handleSOCKS5(clientConn net.Conn, pm *ProxyManager) {
 	logger.Info("Connecting to SOCKS5 proxy at %s", proxyHost)
 
 	// Kết nối tới proxy
+	dialStart := time.Now()
 	proxyConn, err := net.DialTimeout("tcp", proxyHost, 10*time.Second)
 	if err != nil {
 		logger.Error("Failed to connect to SOCKS5 proxy: %v", err)
+		proxy.cancelReservation()
 		sendSocks5Error(clientConn, 0x01)
 		pm.MarkProxyFailed(proxy)
 		return
 	}
+	proxy.recordConnect(time.Since(dialStart))
+	proxyConn = proxy.trackConn(proxyConn)
 	defer proxyConn.Close()
 
+	if proxy.Type == ProxyTypeSOCKS4 {
+		requestStart := time.Now()
+		if err := socks4Connect(proxyConn, proxy, targetHost, targetPort); err != nil {
+			logger.Error("SOCKS4 proxy connection failed: %v", err)
+			sendSocks5Error(clientConn, 0x01)
+			pm.MarkProxyFailed(proxy)
+			return
+		}
+		proxy.recordTTFB(time.Since(requestStart))
+		serveSOCKS5Tunnel(clientConn, pm, proxy, targetAddr, proxyConn)
+		return
+	}
+
 	// Thực hiện bắt tay SOCKS5 với proxy
 	// 1. Xác định phương thức xác thực dựa trên credentials
+	username, password := proxy.credentials()
 	var authMethods []byte
-	if proxy.Username != "" && proxy.Password != "" {
+	if username != "" && password != "" {
 		// Hỗ trợ cả không xác thực (0) và user/pass (2)
 		authMethods = []byte{0x00, 0x02}
 	} else {
@@ -276,15 +287,15 @@ func handleSOCKS5(clientConn net.Conn, pm *ProxyManager) {
 	}
 
 	// Xử lý xác thực username/password nếu cần
-	if authResp[1] == 0x02 && proxy.Username != "" && proxy.Password != "" {
+	if authResp[1] == 0x02 && username != "" && password != "" {
 		logger.Info("Proxy requires username/password authentication")
 
 		// Gửi thông tin xác thực
 		authReq := []byte{0x01} // Version 1 của protocol xác thực
-		authReq = append(authReq, byte(len(proxy.Username)))
-		authReq = append(authReq, []byte(proxy.Username)...)
-		authReq = append(authReq, byte(len(proxy.Password)))
-		authReq = append(authReq, []byte(proxy.Password)...)
+		authReq = append(authReq, byte(len(username)))
+		authReq = append(authReq, []byte(username)...)
+		authReq = append(authReq, byte(len(password)))
+		authReq = append(authReq, []byte(password)...)
 
 		if _, err := proxyConn.Write(authReq); err != nil {
 			logger.Error("Failed to send authentication to proxy: %v", err)
@@ -344,6 +355,7 @@ func handleSOCKS5(clientConn net.Conn, pm *ProxyManager) {
 	request = append(request, byte(targetPort>>8), byte(targetPort))
 
 	// Gửi request
+	requestStart := time.Now()
 	if _, err := proxyConn.Write(request); err != nil {
 		logger.Error("Failed to send connection request to proxy: %v", err)
 		sendSocks5Error(clientConn, 0x01)
@@ -361,6 +373,8 @@ func handleSOCKS5(clientConn net.Conn, pm *ProxyManager) {
 		return
 	}
 
+	proxy.recordTTFB(time.Since(requestStart))
+
 	if reply[0] != SOCKS5_VERSION {
 		logger.Error("Invalid SOCKS version in response: %d", reply[0])
 		sendSocks5Error(clientConn, 0x01)
@@ -394,81 +408,45 @@ func handleSOCKS5(clientConn net.Conn, pm *ProxyManager) {
 		io.ReadFull(proxyConn, skipBytes)
 	}
 
-	// Kết nối đã thành công, trả phản hồi thành công cho client
+	serveSOCKS5Tunnel(clientConn, pm, proxy, targetAddr, proxyConn)
+}
+
+// sendSocks5Error gửi thông báo lỗi SOCKS5 cho client
+func sendSocks5Error(conn net.Conn, errorCode byte) {
 	// VER, REP, RSV, ATYP, BND.ADDR, BND.PORT
-	// Sử dụng 0.0.0.0:0 làm địa chỉ bind
-	successReply := []byte{
+	errorReply := []byte{
 		SOCKS5_VERSION,
-		0x00, // Succeeded
+		errorCode,
 		0x00, // Reserved
 		SOCKS5_ADDR_TYPE_IPV4,
 		0, 0, 0, 0, // IP address (0.0.0.0)
 		0, 0, // Port (0)
 	}
 
-	if _, err := clientConn.Write(successReply); err != nil {
-		logger.Error("Failed to send success response to client: %v", err)
-		return
-	}
-
-	// Đánh dấu proxy này thành công
-	pm.MarkProxySuccess(proxy)
-
-	// Tạo tunnel giữa client và target
-	logger.Info("SOCKS5 connection established to %s via %s", targetAddr, proxy.URL)
-
-	// Xử lý truyền dữ liệu hai chiều
-	handleTLSOverSOCKS5(clientConn, targetAddr, proxyConn)
+	conn.Write(errorReply)
 }
 
-// handleTLSOverSOCKS5 xử lý kết nối TLS qua SOCKS5
-func handleTLSOverSOCKS5(clientConn net.Conn, targetAddr string, proxyConn net.Conn) {
-	// Tạo tunnel giữa client và proxy server
-	logger.Info("SOCKS5 tunnel established to %s", targetAddr)
-
-	// Kiểm tra cấu hình SOCKS5
-	if socks5Config.SkipVerify {
-		logger.Debug("SOCKS5 config: SSL verification skipped")
-	}
-
-	// Đối với SOCKS5, chúng ta không cần xử lý TLS
-	// Chỉ cần tạo tunnel truyền dữ liệu hai chiều
-	// Thư viện TLS của client sẽ tự xử lý handshake và verification
-
-	errChan := make(chan error, 2)
-
-	// Client -> Proxy
-	go func() {
-		_, err := io.Copy(proxyConn, clientConn)
-		errChan <- err
-	}()
-
-	// Proxy -> Client
-	go func() {
-		_, err := io.Copy(clientConn, proxyConn)
-		errChan <- err
-	}()
-
-	// Đợi một trong hai hướng kết thúc
-	err := <-errChan
-	if err != nil && err != io.EOF {
-		logger.Error("SOCKS5 tunnel error: %v", err)
+// serveSOCKS5Direct kết nối thẳng tới đích và chuyển tiếp dữ liệu cho client SOCKS5
+func serveSOCKS5Direct(clientConn net.Conn, targetAddr string) {
+	targetConn, err := dialDirect(targetAddr)
+	if err != nil {
+		logger.Error("Failed to connect directly to target: %v", err)
+		sendSocks5Error(clientConn, 0x04) // Host unreachable
+		return
 	}
+	defer targetConn.Close()
 
-	logger.Info("SOCKS5 connection to %s completed", targetAddr)
-}
-
-// sendSocks5Error gửi thông báo lỗi SOCKS5 cho client
-func sendSocks5Error(conn net.Conn, errorCode byte) {
-	// VER, REP, RSV, ATYP, BND.ADDR, BND.PORT
-	errorReply := []byte{
+	// Gửi phản hồi thành công về client
+	responseHeader := []byte{
 		SOCKS5_VERSION,
-		errorCode,
+		0x00, // Thành công
 		0x00, // Reserved
 		SOCKS5_ADDR_TYPE_IPV4,
-		0, 0, 0, 0, // IP address (0.0.0.0)
-		0, 0, // Port (0)
+		0, 0, 0, 0, // Bất kỳ địa chỉ IP nào
+		0, 0, // Bất kỳ cổng nào
 	}
+	clientConn.Write(responseHeader)
 
-	conn.Write(errorReply)
+	// Chuyển tiếp dữ liệu qua lại giữa client và target
+	handleTLSOverSOCKS5(clientConn, "direct:"+targetAddr, targetConn)
 }
@@ -0,0 +1,72 @@
+package proxy
+
+import (
+	"io"
+	"net"
+)
+
+// serveSOCKS5Tunnel báo kết nối thành công cho client và chuyển tiếp dữ liệu qua proxy upstream
+func serveSOCKS5Tunnel(clientConn net.Conn, pm *ProxyManager, proxy *Proxy, targetAddr string, proxyConn net.Conn) {
+	// Kết nối đã thành công, trả phản hồi thành công cho client
+	// VER, REP, RSV, ATYP, BND.ADDR, BND.PORT
+	// Sử dụng 0.0.0.0:0 làm địa chỉ bind
+	successReply := []byte{
+		SOCKS5_VERSION,
+		0x00, // Succeeded
+		0x00, // Reserved
+		SOCKS5_ADDR_TYPE_IPV4,
+		0, 0, 0, 0, // IP address (0.0.0.0)
+		0, 0, // Port (0)
+	}
+
+	if _, err := clientConn.Write(successReply); err != nil {
+		logger.Error("Failed to send success response to client: %v", err)
+		return
+	}
+
+	// Đánh dấu proxy này thành công
+	pm.MarkProxySuccess(proxy)
+
+	// Tạo tunnel giữa client và target
+	logger.Info("SOCKS5 connection established to %s via %s", targetAddr, proxy.URL)
+
+	// Xử lý truyền dữ liệu hai chiều
+	handleTLSOverSOCKS5(clientConn, targetAddr, proxyConn)
+}
+
+// handleTLSOverSOCKS5 xử lý kết nối TLS qua SOCKS5
+func handleTLSOverSOCKS5(clientConn net.Conn, targetAddr string, proxyConn net.Conn) {
+	// Tạo tunnel giữa client và proxy server
+	logger.Info("SOCKS5 tunnel established to %s", targetAddr)
+
+	// Kiểm tra cấu hình SOCKS5
+	if socks5Config.SkipVerify {
+		logger.Debug("SOCKS5 config: SSL verification skipped")
+	}
+
+	// Đối với SOCKS5, chúng ta không cần xử lý TLS
+	// Chỉ cần tạo tunnel truyền dữ liệu hai chiều
+	// Thư viện TLS của client sẽ tự xử lý handshake và verification
+
+	errChan := make(chan error, 2)
+
+	// Client -> Proxy
+	go func() {
+		_, err := io.Copy(proxyConn, clientConn)
+		errChan <- err
+	}()
+
+	// Proxy -> Client
+	go func() {
+		_, err := io.Copy(clientConn, proxyConn)
+		errChan <- err
+	}()
+
+	// Đợi một trong hai hướng kết thúc
+	err := <-errChan
+	if err != nil && err != io.EOF {
+		logger.Error("SOCKS5 tunnel error: %v", err)
+	}
+
+	logger.Info("SOCKS5 connection to %s completed", targetAddr)
+}
@@ -0,0 +1,275 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// statePoolsBucket bucket chứa trạng thái các pool, mỗi pool một key
+	statePoolsBucket = "pools"
+	// DefaultPoolName tên dùng để lưu trạng thái của pool mặc định
+	DefaultPoolName = "default"
+)
+
+// StateConfig cấu hình lưu trạng thái proxy (breaker, thống kê, ban, IP đầu ra) qua các lần khởi động
+type StateConfig struct {
+	// Path file bbolt lưu trạng thái
+	Path string `json:"path"`
+	// SnapshotInterval chu kỳ ghi trạng thái, mặc định 1m
+	SnapshotInterval string `json:"snapshot_interval,omitempty"`
+}
+
+// proxyState là trạng thái lưu trữ của một proxy, khớp lại theo URL khi khôi phục
+type proxyState struct {
+	URL         string               `json:"url"`
+	State       int                  `json:"state"`
+	OpenUntil   time.Time            `json:"open_until"`
+	Trips       int                  `json:"trips"`
+	FailCount   int                  `json:"fail_count"`
+	LastChecked time.Time            `json:"last_checked"`
+	LastUsed    time.Time            `json:"last_used"`
+	ExitIP      string               `json:"exit_ip,omitempty"`
+	Anonymity   AnonymityLevel       `json:"anonymity,omitempty"`
+	Bans        map[string]time.Time `json:"bans,omitempty"`
+	Stats       ProxyStats           `json:"stats"`
+}
+
+// snapshotState chụp trạng thái của mọi proxy trong pool
+func (pm *ProxyManager) snapshotState() []proxyState {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	now := time.Now()
+	states := make([]proxyState, 0, len(pm.proxies))
+	for _, proxy := range pm.proxies {
+		state := proxyState{
+			URL:         proxy.URL,
+			State:       int(proxy.State),
+			OpenUntil:   proxy.OpenUntil,
+			Trips:       proxy.trips,
+			FailCount:   proxy.FailCount,
+			LastChecked: proxy.LastChecked,
+			LastUsed:    proxy.LastUsed,
+			ExitIP:      proxy.ExitIP,
+			Anonymity:   proxy.Anonymity,
+			Stats:       proxy.Stats(),
+		}
+		for domain, until := range proxy.bans {
+			if now.Before(until) {
+				if state.Bans == nil {
+					state.Bans = make(map[string]time.Time)
+				}
+				state.Bans[domain] = until
+			}
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// restoreState áp trạng thái đã lưu lên các proxy cùng URL, trả về số proxy được khôi phục.
+// urls khác nil giới hạn các proxy được khôi phục.
+func (pm *ProxyManager) restoreState(states []proxyState, urls map[string]bool) int {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	byURL := make(map[string]proxyState, len(states))
+	for _, state := range states {
+		byURL[state.URL] = state
+	}
+
+	restored := 0
+	for _, proxy := range pm.proxies {
+		state, ok := byURL[proxy.URL]
+		if !ok || (urls != nil && !urls[proxy.URL]) {
+			continue
+		}
+
+		proxy.State = BreakerState(state.State)
+		// Request thử đang dở khi tắt server không còn, để breaker cho phép thử lại
+		if proxy.State == BreakerHalfOpen {
+			proxy.State = BreakerOpen
+		}
+		proxy.OpenUntil = state.OpenUntil
+		proxy.trips = state.Trips
+		proxy.FailCount = state.FailCount
+		proxy.LastChecked = state.LastChecked
+		proxy.LastUsed = state.LastUsed
+		if !state.LastUsed.IsZero() {
+			pm.used[proxy.URL] = state.LastUsed
+		}
+		proxy.ExitIP = state.ExitIP
+		proxy.Anonymity = state.Anonymity
+		proxy.bans = state.Bans
+		proxy.restoreStats(state.Stats)
+		enrichGeo(proxy)
+		restored++
+	}
+	return restored
+}
+
+// restoreStats khôi phục số đo đã lưu; cửa sổ tỉ lệ thành công bắt đầu lại từ đầu
+func (p *Proxy) restoreStats(stats ProxyStats) {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+
+	p.stats.lastConnect = stats.LastConnectTime
+	p.stats.avgConnect = stats.AvgConnectTime
+	p.stats.lastTTFB = stats.LastTTFB
+	p.stats.ewmaLatency = stats.EWMALatency
+	p.stats.successes = stats.Successes
+	p.stats.failures = stats.Failures
+	atomic.StoreInt64(&p.stats.bytesSent, stats.BytesSent)
+	atomic.StoreInt64(&p.stats.bytesReceived, stats.BytesReceived)
+}
+
+// SaveState ghi trạng thái pool vào store dưới tên name
+func (pm *ProxyManager) SaveState(store Store, name string) error {
+	data, err := json.Marshal(pm.snapshotState())
+	if err != nil {
+		return err
+	}
+	return store.Put(statePoolsBucket, name, data)
+}
+
+// RestoreState đọc trạng thái pool name từ store, trả về số proxy được khôi phục
+func (pm *ProxyManager) RestoreState(store Store, name string) (int, error) {
+	return pm.restoreStateOf(store, name, nil)
+}
+
+// restoreStateOf như RestoreState nhưng chỉ khôi phục các proxy có URL trong urls (nil là mọi proxy),
+// dùng cho proxy được thêm sau khi pool đã khôi phục trạng thái
+func (pm *ProxyManager) restoreStateOf(store Store, name string, urls map[string]bool) (int, error) {
+	data, err := store.Get(statePoolsBucket, name)
+	if err != nil || data == nil {
+		return 0, err
+	}
+
+	var states []proxyState
+	if err := json.Unmarshal(data, &states); err != nil {
+		return 0, fmt.Errorf("invalid saved state for pool %s: %v", name, err)
+	}
+	return pm.restoreState(states, urls), nil
+}
+
+// StatePersister khôi phục trạng thái các pool khi khởi động và ghi snapshot định kỳ cho tới khi Stop
+type StatePersister struct {
+	store    Store
+	pools    map[string]*ProxyManager
+	interval time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// StartStatePersistence mở store, khôi phục trạng thái các pool (theo tên) rồi bắt đầu ghi định kỳ
+func StartStatePersistence(cfg *StateConfig, pools map[string]*ProxyManager) (*StatePersister, error) {
+	interval := time.Minute
+	if cfg.SnapshotInterval != "" {
+		parsed, err := time.ParseDuration(cfg.SnapshotInterval)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid state snapshot interval %q", cfg.SnapshotInterval)
+		}
+		interval = parsed
+	}
+
+	store, err := OpenBoltStore(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := &StatePersister{
+		store:    store,
+		pools:    pools,
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	for name, pm := range pools {
+		restored, err := pm.RestoreState(store, name)
+		if err != nil {
+			logger.Error("Failed to restore state of pool %s: %v", name, err)
+		} else {
+			logger.Info("Restored state of %d proxies in pool %s", restored, name)
+		}
+		pm.OnReload(sp.restoreAdded(pm, name))
+	}
+	go sp.run()
+	return sp, nil
+}
+
+// restoreAdded trả về hook khôi phục trạng thái đã lưu cho proxy được thêm vào pool sau khi khởi động,
+// ví dụ từ subscription hoặc provider tải xong muộn hay proxy được liệt kê lại khi tải lại nguồn
+func (sp *StatePersister) restoreAdded(pm *ProxyManager, name string) func(ReloadEvent) {
+	return func(event ReloadEvent) {
+		if len(event.Added) == 0 {
+			return
+		}
+		select {
+		case <-sp.stop:
+			return
+		default:
+		}
+
+		urls := make(map[string]bool, len(event.Added))
+		for _, url := range event.Added {
+			urls[url] = true
+		}
+		restored, err := pm.restoreStateOf(sp.store, name, urls)
+		if err != nil {
+			logger.Error("Failed to restore state of proxies added to pool %s: %v", name, err)
+			return
+		}
+		if restored > 0 {
+			logger.Info("Restored state of %d proxies added to pool %s by %s", restored, name, event.Source)
+		}
+	}
+}
+
+// Store trả về store đang dùng để các thành phần khác lưu trạng thái riêng
+func (sp *StatePersister) Store() Store {
+	return sp.store
+}
+
+func (sp *StatePersister) run() {
+	defer close(sp.done)
+
+	ticker := time.NewTicker(sp.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-sp.stop:
+			return
+		case <-ticker.C:
+			sp.Snapshot()
+		}
+	}
+}
+
+// Snapshot ghi trạng thái hiện tại của mọi pool
+func (sp *StatePersister) Snapshot() {
+	for name, pm := range sp.pools {
+		if err := pm.SaveState(sp.store, name); err != nil {
+			logger.Error("Failed to save state of pool %s: %v", name, err)
+		}
+	}
+	logger.Debug("Saved state snapshot of %d pools", len(sp.pools))
+}
+
+// Stop dừng ghi định kỳ, ghi snapshot cuối cùng và đóng store
+func (sp *StatePersister) Stop() {
+	sp.stopOnce.Do(func() {
+		close(sp.stop)
+		<-sp.done
+		sp.Snapshot()
+		if err := sp.store.Close(); err != nil {
+			logger.Error("Failed to close state store: %v", err)
+		}
+	})
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"net/http/httptrace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// ewmaAlpha trọng số của mẫu mới khi tính trung bình trượt hàm mũ
+	ewmaAlpha = 0.3
+
+	// successWindow số kết quả gần nhất dùng để tính tỉ lệ thành công
+	successWindow = 100
+)
+
+// ProxyStats là bản chụp thống kê hiệu năng của một proxy upstream
+type ProxyStats struct {
+	LastConnectTime time.Duration `json:"last_connect_time"`
+	AvgConnectTime  time.Duration `json:"avg_connect_time"`
+	LastTTFB        time.Duration `json:"last_ttfb"`
+	EWMALatency     time.Duration `json:"ewma_latency"`
+
+	// SuccessRate tỉ lệ thành công trên tối đa successWindow kết quả gần nhất
+	SuccessRate float64 `json:"success_rate"`
+	Successes   int64   `json:"successes"`
+	Failures    int64   `json:"failures"`
+
+	BytesSent     int64 `json:"bytes_sent"`
+	BytesReceived int64 `json:"bytes_received"`
+}
+
+// proxyStats gom số đo từ traffic thật và health check, có khóa riêng để
+// các handler ghi nhận mà không cần giữ pm.mu
+type proxyStats struct {
+	mu            sync.Mutex
+	lastConnect   time.Duration
+	avgConnect    time.Duration
+	lastTTFB      time.Duration
+	ewmaLatency   time.Duration
+	outcomes      [successWindow]bool
+	outcomeCount  int
+	outcomeNext   int
+	successes     int64
+	failures      int64
+	bytesSent     int64 // truy cập bằng atomic
+	bytesReceived int64 // truy cập bằng atomic
+}
+
+func ewma(current, sample time.Duration) time.Duration {
+	if current == 0 {
+		return sample
+	}
+	return time.Duration(ewmaAlpha*float64(sample) + (1-ewmaAlpha)*float64(current))
+}
+
+// recordConnect ghi nhận thời gian mở kết nối TCP tới proxy
+func (p *Proxy) recordConnect(d time.Duration) {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	p.stats.lastConnect = d
+	p.stats.avgConnect = ewma(p.stats.avgConnect, d)
+}
+
+// recordTTFB ghi nhận thời gian tới byte phản hồi đầu tiên của upstream
+// (response header với HTTP, phản hồi CONNECT với tunnel)
+func (p *Proxy) recordTTFB(d time.Duration) {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	p.stats.lastTTFB = d
+	p.stats.ewmaLatency = ewma(p.stats.ewmaLatency, d)
+}
+
+// recordResult ghi nhận kết quả của một lần dùng proxy vào cửa sổ trượt
+func (p *Proxy) recordResult(success bool) {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+
+	p.stats.outcomes[p.stats.outcomeNext] = success
+	p.stats.outcomeNext = (p.stats.outcomeNext + 1) % successWindow
+	if p.stats.outcomeCount < successWindow {
+		p.stats.outcomeCount++
+	}
+
+	if success {
+		p.stats.successes++
+	} else {
+		p.stats.failures++
+	}
+}
+
+func (p *Proxy) addBytes(sent, received int64) {
+	if sent > 0 {
+		atomic.AddInt64(&p.stats.bytesSent, sent)
+	}
+	if received > 0 {
+		atomic.AddInt64(&p.stats.bytesReceived, received)
+	}
+}
+
+// clientTrace đo thời gian kết nối tới proxy và thời gian từ lúc gửi xong request
+// tới byte phản hồi đầu tiên cho request qua http.Transport
+func (p *Proxy) clientTrace(contentLength int64) *httptrace.ClientTrace {
+	var connectStart, requestSent time.Time
+	return &httptrace.ClientTrace{
+		ConnectStart: func(_, _ string) {
+			connectStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil {
+				p.recordConnect(time.Since(connectStart))
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			requestSent = time.Now()
+			if info.Err == nil {
+				p.addBytes(contentLength, 0)
+			}
+		},
+		GotFirstResponseByte: func() {
+			p.recordTTFB(time.Since(requestSent))
+		},
+	}
+}
+
+// latency trả về độ trễ EWMA, dùng thời gian kết nối khi chưa có số đo TTFB
+func (p *Proxy) latency() time.Duration {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+	if p.stats.ewmaLatency != 0 {
+		return p.stats.ewmaLatency
+	}
+	return p.stats.avgConnect
+}
+
+// Stats trả về bản chụp thống kê của proxy
+func (p *Proxy) Stats() ProxyStats {
+	p.stats.mu.Lock()
+	defer p.stats.mu.Unlock()
+
+	stats := ProxyStats{
+		LastConnectTime: p.stats.lastConnect,
+		AvgConnectTime:  p.stats.avgConnect,
+		LastTTFB:        p.stats.lastTTFB,
+		EWMALatency:     p.stats.ewmaLatency,
+		SuccessRate:     1,
+		Successes:       p.stats.successes,
+		Failures:        p.stats.failures,
+		BytesSent:       atomic.LoadInt64(&p.stats.bytesSent),
+		BytesReceived:   atomic.LoadInt64(&p.stats.bytesReceived),
+	}
+
+	if p.stats.outcomeCount > 0 {
+		ok := 0
+		for i := 0; i < p.stats.outcomeCount; i++ {
+			if p.stats.outcomes[i] {
+				ok++
+			}
+		}
+		stats.SuccessRate = float64(ok) / float64(p.stats.outcomeCount)
+	}
+
+	return stats
+}
+
+// ProxyInfo mô tả trạng thái một proxy cho API, không chứa thông tin đăng nhập
+type ProxyInfo struct {
+	URL         string       `json:"url"`
+	Type        ProxyType    `json:"type"`
+	State       BreakerState `json:"state"`
+	OpenUntil   *time.Time   `json:"open_until,omitempty"`
+	FailCount   int          `json:"fail_count"`
+	LastChecked time.Time    `json:"last_checked"`
+	LastUsed    time.Time    `json:"last_used"`
+	Weight      int          `json:"weight"`
+	ActiveConns int64        `json:"active_conns"`
+	Saturated   bool         `json:"saturated,omitempty"`
+	Stats       ProxyStats   `json:"stats"`
+
+	ExitIP    string         `json:"exit_ip,omitempty"`
+	Anonymity AnonymityLevel `json:"anonymity,omitempty"`
+	// SharedExitIPWith các proxy khác có cùng IP đầu ra
+	SharedExitIPWith []string `json:"shared_exit_ip_with,omitempty"`
+
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+	Region  string `json:"region,omitempty"`
+	ISP     string `json:"isp,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+
+	Tags       []string   `json:"tags,omitempty"`
+	Provider   string     `json:"provider,omitempty"`
+	ProviderID string     `json:"provider_id,omitempty"`
+	MaxConns   int        `json:"max_conns,omitempty"`
+	CostPerGB  float64    `json:"cost_per_gb,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Notes      string     `json:"notes,omitempty"`
+}
+
+// ProxyInfos trả về trạng thái và thống kê của tất cả proxy trong pool
+func (pm *ProxyManager) ProxyInfos() []ProxyInfo {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+
+	shared := pm.sharedExitIPs()
+
+	infos := make([]ProxyInfo, 0, len(pm.proxies))
+	for _, proxy := range pm.proxies {
+		infos = append(infos, ProxyInfo{
+			URL:         proxy.URL,
+			Type:        proxy.Type,
+			State:       proxy.State,
+			FailCount:   proxy.FailCount,
+			LastChecked: proxy.LastChecked,
+			LastUsed:    proxy.LastUsed,
+			Weight:      proxy.weight(),
+			ActiveConns: proxy.ActiveConns(),
+			Saturated:   proxy.Saturated(),
+			Stats:       proxy.Stats(),
+			ExitIP:      proxy.ExitIP,
+			Anonymity:   proxy.Anonymity,
+			Country:     proxy.Country,
+			City:        proxy.City,
+			Region:      proxy.Region,
+			ISP:         proxy.ISP,
+			ASN:         proxy.ASN,
+			Tags:        proxy.Tags,
+			Provider:    proxy.Provider,
+			ProviderID:  proxy.ProviderID,
+			MaxConns:    proxy.MaxConns,
+			CostPerGB:   proxy.CostPerGB,
+			Notes:       proxy.Notes,
+		})
+
+		for _, other := range shared[proxy.ExitIP] {
+			if other != proxy.URL {
+				infos[len(infos)-1].SharedExitIPWith = append(infos[len(infos)-1].SharedExitIPWith, other)
+			}
+		}
+
+		if proxy.State == BreakerOpen {
+			openUntil := proxy.OpenUntil
+			infos[len(infos)-1].OpenUntil = &openUntil
+		}
+		if !proxy.ExpiresAt.IsZero() {
+			expiresAt := proxy.ExpiresAt
+			infos[len(infos)-1].ExpiresAt = &expiresAt
+		}
+	}
+	return infos
+}
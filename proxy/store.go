@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"fmt"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Store là kho lưu trữ khóa-giá trị theo bucket cho trạng thái cần giữ qua các lần khởi động
+type Store interface {
+	// Put ghi value vào key trong bucket, tạo bucket nếu chưa có
+	Put(bucket, key string, value []byte) error
+	// Get đọc value của key, trả về nil nếu không có
+	Get(bucket, key string) ([]byte, error)
+	// Delete xóa key khỏi bucket
+	Delete(bucket, key string) error
+	// ForEach duyệt mọi key trong bucket
+	ForEach(bucket string, fn func(key string, value []byte) error) error
+	Close() error
+}
+
+// boltStore lưu trạng thái vào một file bbolt
+type boltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore mở (hoặc tạo) file bbolt tại path
+func OpenBoltStore(path string) (Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state store %s: %v", path, err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) Put(bucket, key string, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(key), value)
+	})
+}
+
+func (s *boltStore) Get(bucket, key string) ([]byte, error) {
+	var value []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		// Giá trị chỉ hợp lệ trong transaction nên phải sao chép
+		if v := b.Get([]byte(key)); v != nil {
+			value = append([]byte(nil), v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s *boltStore) Delete(bucket, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.Delete([]byte(key))
+	})
+}
+
+func (s *boltStore) ForEach(bucket string, fn func(key string, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			return fn(string(k), append([]byte(nil), v...))
+		})
+	})
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
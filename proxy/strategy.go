@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Strategy chọn một proxy trong danh sách ứng viên đã lọc (luôn khác rỗng).
+// Select được gọi khi đang giữ pm.mu nên có thể đọc trực tiếp các trường của Proxy.
+type Strategy interface {
+	Name() string
+	Select(candidates []*Proxy) *Proxy
+}
+
+// Tên các strategy có sẵn
+const (
+	StrategyRandom            = "random"
+	StrategyRoundRobin        = "round-robin"
+	StrategyWeightedRandom    = "weighted-random"
+	StrategyLeastRecentlyUsed = "least-recently-used"
+	StrategyLeastActive       = "least-active"
+	StrategyLowestLatency     = "lowest-latency"
+	StrategyPowerOfTwoChoices = "power-of-two"
+)
+
+// NewStrategy tạo strategy theo tên, mỗi lần gọi trả về một instance với trạng thái riêng
+func NewStrategy(name string) (Strategy, error) {
+	switch strings.ToLower(name) {
+	case StrategyRandom:
+		return &randomStrategy{rand: newLockedRand()}, nil
+	case StrategyRoundRobin:
+		return &roundRobinStrategy{}, nil
+	case StrategyWeightedRandom:
+		return &weightedRandomStrategy{rand: newLockedRand()}, nil
+	case StrategyLeastRecentlyUsed, "lru":
+		return leastRecentlyUsedStrategy{}, nil
+	case StrategyLeastActive:
+		return leastActiveStrategy{}, nil
+	case StrategyLowestLatency:
+		return lowestLatencyStrategy{}, nil
+	case StrategyPowerOfTwoChoices, "p2c":
+		return &powerOfTwoStrategy{rand: newLockedRand()}, nil
+	}
+	return nil, fmt.Errorf("unknown selection strategy %q", name)
+}
+
+// lockedRand là nguồn ngẫu nhiên dùng được từ nhiều goroutine
+type lockedRand struct {
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+func newLockedRand() *lockedRand {
+	return &lockedRand{rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+func (r *lockedRand) Intn(n int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rand.Intn(n)
+}
+
+type randomStrategy struct {
+	rand *lockedRand
+}
+
+func (s *randomStrategy) Name() string { return StrategyRandom }
+
+func (s *randomStrategy) Select(candidates []*Proxy) *Proxy {
+	return candidates[s.rand.Intn(len(candidates))]
+}
+
+type roundRobinStrategy struct {
+	next uint64
+}
+
+func (s *roundRobinStrategy) Name() string { return StrategyRoundRobin }
+
+func (s *roundRobinStrategy) Select(candidates []*Proxy) *Proxy {
+	n := atomic.AddUint64(&s.next, 1) - 1
+	return candidates[n%uint64(len(candidates))]
+}
+
+// weightedRandomStrategy chọn ngẫu nhiên với xác suất tỉ lệ với Weight (mặc định 1)
+type weightedRandomStrategy struct {
+	rand *lockedRand
+}
+
+func (s *weightedRandomStrategy) Name() string { return StrategyWeightedRandom }
+
+func (s *weightedRandomStrategy) Select(candidates []*Proxy) *Proxy {
+	total := 0
+	for _, proxy := range candidates {
+		total += proxy.weight()
+	}
+
+	n := s.rand.Intn(total)
+	for _, proxy := range candidates {
+		n -= proxy.weight()
+		if n < 0 {
+			return proxy
+		}
+	}
+	return candidates[len(candidates)-1]
+}
+
+// leastRecentlyUsedStrategy chọn proxy chưa dùng hoặc dùng lâu nhất
+type leastRecentlyUsedStrategy struct{}
+
+func (leastRecentlyUsedStrategy) Name() string { return StrategyLeastRecentlyUsed }
+
+func (leastRecentlyUsedStrategy) Select(candidates []*Proxy) *Proxy {
+	selected := candidates[0]
+	for _, proxy := range candidates[1:] {
+		if proxy.LastUsed.Before(selected.LastUsed) {
+			selected = proxy
+		}
+	}
+	return selected
+}
+
+// leastActiveStrategy chọn proxy có ít kết nối đang mở nhất, hòa thì chọn proxy dùng lâu nhất
+type leastActiveStrategy struct{}
+
+func (leastActiveStrategy) Name() string { return StrategyLeastActive }
+
+func (leastActiveStrategy) Select(candidates []*Proxy) *Proxy {
+	selected := candidates[0]
+	for _, proxy := range candidates[1:] {
+		active, selectedActive := proxy.ActiveConns(), selected.ActiveConns()
+		if active < selectedActive || (active == selectedActive && proxy.LastUsed.Before(selected.LastUsed)) {
+			selected = proxy
+		}
+	}
+	return selected
+}
+
+// lowestLatencyStrategy chọn proxy có độ trễ đo được thấp nhất,
+// proxy chưa có số đo được ưu tiên để thu thập độ trễ
+type lowestLatencyStrategy struct{}
+
+func (lowestLatencyStrategy) Name() string { return StrategyLowestLatency }
+
+func (lowestLatencyStrategy) Select(candidates []*Proxy) *Proxy {
+	var selected *Proxy
+	var selectedLatency time.Duration
+	for _, proxy := range candidates {
+		latency := proxy.latency()
+		if latency == 0 {
+			return proxy
+		}
+		if selected == nil || latency < selectedLatency {
+			selected, selectedLatency = proxy, latency
+		}
+	}
+	return selected
+}
+
+// powerOfTwoStrategy lấy ngẫu nhiên hai proxy và chọn proxy ít kết nối đang mở hơn
+type powerOfTwoStrategy struct {
+	rand *lockedRand
+}
+
+func (s *powerOfTwoStrategy) Name() string { return StrategyPowerOfTwoChoices }
+
+func (s *powerOfTwoStrategy) Select(candidates []*Proxy) *Proxy {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	i := s.rand.Intn(len(candidates))
+	j := s.rand.Intn(len(candidates) - 1)
+	if j >= i {
+		j++
+	}
+
+	a, b := candidates[i], candidates[j]
+	if b.ActiveConns() < a.ActiveConns() || (b.ActiveConns() == a.ActiveConns() && b.latency() != 0 && b.latency() < a.latency()) {
+		return b
+	}
+	return a
+}
+
+func (p *Proxy) weight() int {
+	if p.Weight <= 0 {
+		return 1
+	}
+	return p.Weight
+}
+
+// ActiveConns trả về số kết nối đang mở qua proxy
+func (p *Proxy) ActiveConns() int64 {
+	return atomic.LoadInt64(&p.activeConns)
+}
+
+// acquire tính một kết nối đang mở qua proxy, trả về các limiter đã tính để release trả lại đúng
+// các limiter đó kể cả khi cấu hình giới hạn được tải lại trong lúc kết nối còn mở
+func (p *Proxy) acquire() []*limiter {
+	atomic.AddInt64(&p.activeConns, 1)
+	limiters := p.currentLimiters()
+	for _, l := range limiters {
+		l.acquire()
+	}
+	return limiters
+}
+
+func (p *Proxy) release(limiters []*limiter) {
+	atomic.AddInt64(&p.activeConns, -1)
+	for _, l := range limiters {
+		l.release()
+	}
+}
+
+// trackedConn đếm lưu lượng qua proxy và giảm bộ đếm kết nối khi được đóng
+type trackedConn struct {
+	net.Conn
+	proxy    *Proxy
+	limiters []*limiter
+	once     sync.Once
+}
+
+// trackConn đếm conn là một kết nối đang mở qua proxy cho tới khi conn được đóng
+func (p *Proxy) trackConn(conn net.Conn) net.Conn {
+	return &trackedConn{Conn: conn, proxy: p, limiters: p.acquire()}
+}
+
+func (c *trackedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.proxy.addBytes(0, int64(n))
+	return n, err
+}
+
+func (c *trackedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.proxy.addBytes(int64(n), 0)
+	return n, err
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.proxy.release(c.limiters) })
+	return c.Conn.Close()
+}
+
+// CloseWrite chuyển tiếp half-close tới kết nối gốc nếu được hỗ trợ
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
+
+// trackedBody đếm lưu lượng body response và giảm bộ đếm kết nối khi body được đóng
+type trackedBody struct {
+	io.ReadCloser
+	proxy    *Proxy
+	limiters []*limiter
+	once     sync.Once
+}
+
+// trackBody giữ kết nối đã acquire (limiters là kết quả của acquire) trong lúc response được đọc,
+// kết nối chỉ được release khi body được đóng
+func (p *Proxy) trackBody(body io.ReadCloser, limiters []*limiter) io.ReadCloser {
+	return &trackedBody{ReadCloser: body, proxy: p, limiters: limiters}
+}
+
+func (b *trackedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.proxy.addBytes(0, int64(n))
+	return n, err
+}
+
+func (b *trackedBody) Close() error {
+	b.once.Do(func() { b.proxy.release(b.limiters) })
+	return b.ReadCloser.Close()
+}
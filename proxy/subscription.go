@@ -0,0 +1,285 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// maxSubscriptionBody giới hạn kích thước danh sách proxy tải về
+const maxSubscriptionBody = 32 << 20
+
+// SubscriptionConfig cấu hình một nguồn danh sách proxy tải định kỳ qua HTTP
+type SubscriptionConfig struct {
+	// Name tên nguồn trong log và sự kiện reload, mặc định là URL không kèm query
+	Name string `json:"name,omitempty"`
+	URL  string `json:"url"`
+	// Pool pool nhận proxy (pool mặc định hoặc pool có file riêng), mặc định là pool mặc định
+	Pool string `json:"pool,omitempty"`
+	// Interval chu kỳ tải lại, mặc định 10m
+	Interval string `json:"interval,omitempty"`
+	// Timeout thời gian chờ tối đa mỗi lần tải, mặc định 30s
+	Timeout string `json:"timeout,omitempty"`
+	// Headers header gửi kèm request, ví dụ Authorization
+	Headers map[string]string `json:"headers,omitempty"`
+	// Format định dạng danh sách: text (mặc định), json hoặc csv
+	Format string `json:"format,omitempty"`
+	// Type loại proxy cho các proxy không khai báo scheme, mặc định http
+	Type string `json:"type,omitempty"`
+	// CacheFile lưu bản tải thành công gần nhất, dùng khi khởi động mà nguồn không truy cập được
+	CacheFile string `json:"cache_file,omitempty"`
+}
+
+// Subscription tải danh sách proxy từ một URL theo chu kỳ và áp vào pool theo diff.
+// Khi tải hoặc phân tích lỗi, pool giữ nguyên danh sách tốt gần nhất.
+type Subscription struct {
+	cfg         SubscriptionConfig
+	name        string
+	pm          *ProxyManager
+	client      *http.Client
+	interval    time.Duration
+	defaultType ProxyType
+
+	// ETag và Last-Modified của lần tải thành công gần nhất cho request có điều kiện
+	etag         string
+	lastModified string
+
+	refresh  chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// StartSubscriptions khởi động các subscription, pools là các pool có danh sách proxy riêng theo tên.
+// Lần tải đầu tiên chạy đồng bộ để proxy có sẵn trước khi nhận request.
+func StartSubscriptions(cfgs []SubscriptionConfig, pools map[string]*ProxyManager) ([]*Subscription, error) {
+	subscriptions := make([]*Subscription, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		s, err := newSubscription(cfg, pools)
+		if err != nil {
+			for _, started := range subscriptions {
+				started.Stop()
+			}
+			return nil, err
+		}
+
+		s.initialLoad()
+		go s.run()
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, nil
+}
+
+func newSubscription(cfg SubscriptionConfig, pools map[string]*ProxyManager) (*Subscription, error) {
+	// URL và header có thể chứa token dạng tham chiếu bí mật
+	rawURL, err := ResolveSecrets(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("subscription %q: url: %v", cfg.Name, err)
+	}
+	headers, err := resolveHeaders(cfg.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("subscription %q: %v", cfg.Name, err)
+	}
+	cfg.URL, cfg.Headers = rawURL, headers
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("subscription %q: invalid url", cfg.Name)
+	}
+
+	name := cfg.Name
+	if name == "" {
+		name = u.Scheme + "://" + u.Host + u.Path
+	}
+
+	poolName := cfg.Pool
+	if poolName == "" {
+		poolName = DefaultPoolName
+	}
+	pm, ok := pools[poolName]
+	if !ok {
+		return nil, fmt.Errorf("subscription %s: unknown pool %q or pool has no proxy files", name, poolName)
+	}
+
+	defaultType := ProxyTypeHTTP
+	if cfg.Type != "" {
+		if defaultType, ok = proxySchemes[cfg.Type]; !ok {
+			return nil, fmt.Errorf("subscription %s: unsupported type %q", name, cfg.Type)
+		}
+	}
+
+	switch cfg.Format {
+	case "", FormatText, FormatJSON, FormatCSV:
+	default:
+		return nil, fmt.Errorf("subscription %s: unsupported format %q", name, cfg.Format)
+	}
+
+	interval, err := parseSubscriptionDuration(cfg.Interval, 10*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("subscription %s: invalid interval: %v", name, err)
+	}
+	timeout, err := parseSubscriptionDuration(cfg.Timeout, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("subscription %s: invalid timeout: %v", name, err)
+	}
+
+	return &Subscription{
+		cfg:         cfg,
+		name:        name,
+		pm:          pm,
+		client:      &http.Client{Timeout: timeout},
+		interval:    interval,
+		defaultType: defaultType,
+		refresh:     make(chan struct{}, 1),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}, nil
+}
+
+func parseSubscriptionDuration(value string, fallback time.Duration) (time.Duration, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("must be positive")
+	}
+	return d, nil
+}
+
+// initialLoad tải lần đầu, dùng bản lưu trong cache_file nếu nguồn không truy cập được
+func (s *Subscription) initialLoad() {
+	err := s.fetch()
+	if err == nil || s.cfg.CacheFile == "" {
+		if err != nil {
+			logger.Error("Subscription %s: %v", s.name, err)
+		}
+		return
+	}
+
+	logger.Warn("Subscription %s: %v, using cached list %s", s.name, err, s.cfg.CacheFile)
+	data, readErr := os.ReadFile(s.cfg.CacheFile)
+	if readErr != nil {
+		logger.Error("Subscription %s: failed to read cached list: %v", s.name, readErr)
+		return
+	}
+	if applyErr := s.apply(data); applyErr != nil {
+		logger.Error("Subscription %s: cached list: %v", s.name, applyErr)
+	}
+}
+
+func (s *Subscription) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+		case <-s.refresh:
+		}
+		if err := s.fetch(); err != nil {
+			logger.Error("Subscription %s: %v, keeping last good list", s.name, err)
+		}
+	}
+}
+
+// fetch tải danh sách, gửi If-None-Match/If-Modified-Since để bỏ qua khi danh sách không đổi
+func (s *Subscription) fetch() error {
+	req, err := http.NewRequest(http.MethodGet, s.cfg.URL, nil)
+	if err != nil {
+		return err
+	}
+	for name, value := range s.cfg.Headers {
+		req.Header.Set(name, value)
+	}
+	if s.etag != "" {
+		req.Header.Set("If-None-Match", s.etag)
+	}
+	if s.lastModified != "" {
+		req.Header.Set("If-Modified-Since", s.lastModified)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// Bỏ URL khỏi lỗi vì query có thể chứa token
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("fetch failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		logger.Debug("Subscription %s not modified", s.name)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch failed: status %d", resp.StatusCode)
+	}
+
+	// Đọc quá giới hạn một byte để phát hiện danh sách quá lớn thay vì cắt cụt nó
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSubscriptionBody+1))
+	if err != nil {
+		return fmt.Errorf("failed to read list: %v", err)
+	}
+	if len(data) > maxSubscriptionBody {
+		return fmt.Errorf("list exceeds %d bytes", maxSubscriptionBody)
+	}
+	if err := s.apply(data); err != nil {
+		return err
+	}
+
+	s.etag = resp.Header.Get("ETag")
+	s.lastModified = resp.Header.Get("Last-Modified")
+	if s.cfg.CacheFile != "" {
+		if err := os.WriteFile(s.cfg.CacheFile, data, 0600); err != nil {
+			logger.Error("Subscription %s: failed to write cached list: %v", s.name, err)
+		}
+	}
+	return nil
+}
+
+// apply phân tích danh sách và áp vào pool. Danh sách không có proxy hợp lệ nào bị từ chối để
+// một phản hồi hỏng không xóa sạch pool.
+func (s *Subscription) apply(data []byte) error {
+	proxies, lineErrors, err := ParseProxyData(data, s.cfg.Format, s.defaultType)
+	if err != nil {
+		return err
+	}
+	for _, lineErr := range lineErrors {
+		logger.Warn("Subscription %s: invalid proxy at %v", s.name, lineErr)
+	}
+	if len(proxies) == 0 {
+		return fmt.Errorf("list has no valid proxies")
+	}
+
+	s.pm.ReloadSource(s.name, proxies, len(lineErrors))
+	return nil
+}
+
+// Refresh yêu cầu tải lại ngay, không chờ tới chu kỳ tiếp theo
+func (s *Subscription) Refresh() {
+	select {
+	case s.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Stop dừng tải định kỳ
+func (s *Subscription) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stop)
+		<-s.done
+	})
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// TLSConfig cấu hình TLS cho listener nhận kết nối từ client
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+
+	// ClientCAFile bật xác thực client bằng chứng chỉ được ký bởi CA này
+	ClientCAFile string `json:"client_ca_file,omitempty"`
+}
+
+// buildServerTLSConfig tạo tls.Config từ cấu hình listener
+func buildServerTLSConfig(cfg *TLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %v", err)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
+
+	if cfg.ClientCAFile != "" {
+		caPEM, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA file: %v", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPEM) {
+			return nil, fmt.Errorf("no valid certificates in client CA file %s", cfg.ClientCAFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return tlsConfig, nil
+}
+
+// StartTLSProxyServer khởi động proxy server bọc trong TLS, bên trong vẫn là HTTP proxy hoặc SOCKS5
+func StartTLSProxyServer(pm *ProxyManager, lc ListenerConfig) error {
+	tlsConfig, err := buildServerTLSConfig(lc.TLS)
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", lc.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", lc.Addr, err)
+	}
+
+	logger.Info("Starting TLS proxy server on %s (client auth: %v)", lc.Addr, lc.TLS.ClientCAFile != "")
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Error("Failed to accept connection: %v", err)
+			continue
+		}
+
+		go func() {
+			tlsConn := tls.Server(conn, tlsConfig)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				logger.Error("TLS handshake with %s failed: %v", conn.RemoteAddr(), err)
+				tlsConn.Close()
+				return
+			}
+
+			// Client chọn HTTP/2 qua ALPN, các trường hợp khác dùng HTTP/1.x hoặc SOCKS5
+			if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+				serveHTTP2(tlsConn, pm)
+				return
+			}
+
+			handleProxyConnection(tlsConn, pm)
+		}()
+	}
+}
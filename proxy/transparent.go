@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// Kích thước tối đa của một TLS record chứa ClientHello (16KB + 5 byte header)
+const maxClientHelloSize = 16*1024 + 5
+
+var errSniffDone = errors.New("sniff done")
+
+// StartTransparentServer khởi động listener cho traffic được chuyển hướng bằng iptables
+// (REDIRECT hoặc TPROXY), client không cần cấu hình proxy
+func StartTransparentServer(pm *ProxyManager, lc ListenerConfig) error {
+	listener, err := listenTransparent(lc.Addr, lc.TProxy)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", lc.Addr, err)
+	}
+
+	logger.Info("Starting transparent proxy server on %s (tproxy: %v)", lc.Addr, lc.TProxy)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logger.Error("Failed to accept connection: %v", err)
+			continue
+		}
+
+		go handleTransparentConnection(conn, pm, lc.TProxy)
+	}
+}
+
+// handleTransparentConnection xác định đích gốc của kết nối rồi chuyển tiếp qua proxy upstream
+func handleTransparentConnection(clientConn net.Conn, pm *ProxyManager, tproxy bool) {
+	defer clientConn.Close()
+
+	// Với TPROXY, địa chỉ local của socket chính là đích gốc
+	var origDst *net.TCPAddr
+	var err error
+	if tproxy {
+		origDst, _ = clientConn.LocalAddr().(*net.TCPAddr)
+		if origDst == nil {
+			err = fmt.Errorf("unexpected local address %v", clientConn.LocalAddr())
+		}
+	} else {
+		origDst, err = originalDst(clientConn)
+	}
+	if err != nil {
+		logger.Error("Failed to get original destination: %v", err)
+		return
+	}
+
+	// Đọc trước dữ liệu đầu tiên để lấy tên miền cho routing
+	reader := bufio.NewReaderSize(clientConn, maxClientHelloSize)
+	clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	host := sniffHost(reader)
+	clientConn.SetReadDeadline(time.Time{})
+
+	if host == "" {
+		host = origDst.IP.String()
+	}
+	hostPort := net.JoinHostPort(host, fmt.Sprint(origDst.Port))
+	logger.Info("Transparent connection from %s to %s (original %s)", clientConn.RemoteAddr(), hostPort, origDst)
+
+	// Định tuyến theo đích và client trước khi chọn proxy
+	client := &readConn{Reader: reader, Conn: clientConn}
+	action, routed := route(pm, hostPort, clientConn.RemoteAddr().String())
+	switch action {
+	case RouteActionReject:
+		logger.Info("Transparent connection to %s rejected by routing rules", hostPort)
+		return
+	case RouteActionDirect:
+		targetConn, err := dialDirect(origDst.String())
+		if err != nil {
+			logger.Error("Direct connection to %s failed: %v", hostPort, err)
+			return
+		}
+		logger.Info("Transparent connection established directly to %s", hostPort)
+		copyData(targetConn, client)
+		return
+	}
+
+	proxyConn, proxy, err := dialUpstreamTunnel(routed, hostPort)
+	if err != nil {
+		logger.Error("All transparent proxy attempts failed after %d retries, last error: %v", routed.maxRetries, err)
+		return
+	}
+	logger.Info("Transparent tunnel established via proxy %s to %s", proxy.URL, hostPort)
+
+	// Dữ liệu đã đọc trước vẫn nằm trong reader, chuyển tiếp cùng phần còn lại
+	copyData(proxyConn, client)
+}
+
+// sniffHost trả về tên miền từ SNI của TLS ClientHello hoặc header Host của HTTP
+func sniffHost(reader *bufio.Reader) string {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return ""
+	}
+
+	// 0x16 = TLS handshake record
+	if first[0] == 0x16 {
+		return sniffSNI(reader)
+	}
+	return sniffHTTPHost(reader)
+}
+
+// sniffSNI đọc trước TLS record đầu tiên và lấy server name từ ClientHello
+func sniffSNI(reader *bufio.Reader) string {
+	header, err := reader.Peek(5)
+	if err != nil {
+		return ""
+	}
+
+	recordLen := int(header[3])<<8 | int(header[4])
+	record, err := reader.Peek(5 + recordLen)
+	if err != nil {
+		return ""
+	}
+
+	// Cho crypto/tls tự parse ClientHello và dừng handshake ngay sau đó
+	var serverName string
+	conn := tls.Server(&sniffConn{Reader: bytes.NewReader(record)}, &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			serverName = hello.ServerName
+			return nil, errSniffDone
+		},
+	})
+	conn.Handshake()
+
+	return serverName
+}
+
+// sniffHTTPHost đọc trước phần header HTTP và lấy giá trị Host (bỏ port)
+func sniffHTTPHost(reader *bufio.Reader) string {
+	data, err := reader.Peek(reader.Buffered())
+	for err == nil && !bytes.Contains(data, []byte("\r\n\r\n")) && len(data) < reader.Size() {
+		data, err = reader.Peek(len(data) + 1)
+	}
+
+	for _, line := range strings.Split(string(data), "\r\n")[1:] {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.EqualFold(strings.TrimSpace(parts[0]), "host") {
+			host := strings.TrimSpace(parts[1])
+			if h, _, err := net.SplitHostPort(host); err == nil {
+				host = h
+			}
+			return host
+		}
+	}
+	return ""
+}
+
+// sniffConn là net.Conn chỉ đọc dùng để parse ClientHello
+type sniffConn struct {
+	io.Reader
+	net.Conn
+}
+
+func (c *sniffConn) Read(b []byte) (int, error) {
+	return c.Reader.Read(b)
+}
+
+func (c *sniffConn) Write(b []byte) (int, error) {
+	return 0, io.ErrClosedPipe
+}
+
+func (c *sniffConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *sniffConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+func (c *sniffConn) Close() error {
+	return nil
+}
+
+func (c *sniffConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *sniffConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *sniffConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// SO_ORIGINAL_DST / IP6T_SO_ORIGINAL_DST trong netfilter
+const soOriginalDst = 80
+
+// listenTransparent mở listener TCP, bật IP_TRANSPARENT khi dùng TPROXY
+func listenTransparent(addr string, tproxy bool) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if tproxy {
+		lc.Control = func(network, address string, c syscall.RawConn) error {
+			var sockErr error
+			err := c.Control(func(fd uintptr) {
+				sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_IP, syscall.IP_TRANSPARENT, 1)
+			})
+			if err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// originalDst đọc địa chỉ đích gốc của kết nối bị iptables REDIRECT
+func originalDst(conn net.Conn) (*net.TCPAddr, error) {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("not a TCP connection")
+	}
+
+	rawConn, err := tcpConn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	isIPv6 := false
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); ok && local.IP.To4() == nil {
+		isIPv6 = true
+	}
+
+	var addr *net.TCPAddr
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		if isIPv6 {
+			var raw syscall.RawSockaddrInet6
+			size := uint32(unsafe.Sizeof(raw))
+			sockErr = getsockopt(int(fd), syscall.SOL_IPV6, soOriginalDst, unsafe.Pointer(&raw), &size)
+			port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&raw.Port))[:])
+			addr = &net.TCPAddr{IP: net.IP(raw.Addr[:]), Port: int(port)}
+			return
+		}
+
+		var raw syscall.RawSockaddrInet4
+		size := uint32(unsafe.Sizeof(raw))
+		sockErr = getsockopt(int(fd), syscall.SOL_IP, soOriginalDst, unsafe.Pointer(&raw), &size)
+		port := binary.BigEndian.Uint16((*[2]byte)(unsafe.Pointer(&raw.Port))[:])
+		addr = &net.TCPAddr{IP: net.IP(raw.Addr[:]), Port: int(port)}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, fmt.Errorf("getsockopt SO_ORIGINAL_DST: %v", sockErr)
+	}
+
+	return addr, nil
+}
+
+func getsockopt(fd, level, name int, val unsafe.Pointer, size *uint32) error {
+	_, _, errno := syscall.Syscall6(syscall.SYS_GETSOCKOPT, uintptr(fd), uintptr(level), uintptr(name),
+		uintptr(val), uintptr(unsafe.Pointer(size)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package proxy
+
+import (
+	"fmt"
+	"net"
+)
+
+// listenTransparent chỉ được hỗ trợ trên Linux
+func listenTransparent(addr string, tproxy bool) (net.Listener, error) {
+	return nil, fmt.Errorf("transparent mode is only supported on linux")
+}
+
+// originalDst chỉ được hỗ trợ trên Linux
+func originalDst(conn net.Conn) (*net.TCPAddr, error) {
+	return nil, fmt.Errorf("transparent mode is only supported on linux")
+}
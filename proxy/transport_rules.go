@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"net/http"
+)
+
+// HeaderRules thêm/xóa header của request trước khi gửi qua proxy upstream
+type HeaderRules struct {
+	Set    map[string]string `json:"set,omitempty"`
+	Remove []string          `json:"remove,omitempty"`
+}
+
+// apply áp dụng các rule lên header của request
+func (r *HeaderRules) apply(header http.Header) {
+	if r == nil {
+		return
+	}
+	for _, key := range r.Remove {
+		header.Del(key)
+	}
+	for key, value := range r.Set {
+		header.Set(key, value)
+	}
+}
+
+// SetRetryStatusCodes đặt các mã trạng thái khiến request được thử lại với proxy khác
+func (pm *ProxyManager) SetRetryStatusCodes(codes []int) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.retryStatus = make(map[int]bool, len(codes))
+	for _, code := range codes {
+		pm.retryStatus[code] = true
+	}
+}
+
+// SetHeaderRules đặt rule header cho các request đi qua ProxyTransport
+func (pm *ProxyManager) SetHeaderRules(rules *HeaderRules) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.headerRules = rules
+}
+
+// shouldRetryStatus kiểm tra mã trạng thái có nằm trong danh sách cần thử lại
+func (pm *ProxyManager) shouldRetryStatus(code int) bool {
+	pm.mu.RLock()
+	defer pm.mu.RUnlock()
+	return pm.retryStatus[code]
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce thời gian chờ file ngừng thay đổi trước khi tải lại, gộp các lần ghi liên tiếp
+const watchDebounce = 500 * time.Millisecond
+
+// ProxyListWatcher theo dõi các file proxy của một pool bằng inotify và tải lại theo diff khi file thay đổi
+type ProxyListWatcher struct {
+	pm      *ProxyManager
+	files   map[string]watchedFile // Theo đường dẫn tuyệt đối
+	watcher *fsnotify.Watcher
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// watchedFile là một file proxy đang được theo dõi
+type watchedFile struct {
+	name      string // Tên file như trong cấu hình, dùng làm nguồn khi tải lại
+	proxyType ProxyType
+	inventory bool // File inventory có metadata, định dạng theo phần mở rộng
+}
+
+// WatchProxyLists bắt đầu theo dõi các file proxy và file inventory của pm. Thư mục chứa file được theo dõi thay vì
+// chính file để vẫn nhận được thay đổi khi file bị thay thế bằng rename (ghi file tạm rồi đổi tên).
+func WatchProxyLists(httpFile, socks5File string, inventoryFiles []string, pm *ProxyManager) (*ProxyListWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file watcher: %v", err)
+	}
+
+	w := &ProxyListWatcher{
+		pm:      pm,
+		files:   make(map[string]watchedFile),
+		watcher: watcher,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	files := []watchedFile{{name: httpFile, proxyType: ProxyTypeHTTP}, {name: socks5File, proxyType: ProxyTypeSOCKS5}}
+	for _, name := range inventoryFiles {
+		files = append(files, watchedFile{name: name, inventory: true})
+	}
+
+	for _, f := range files {
+		if f.name == "" {
+			continue
+		}
+		path, err := filepath.Abs(f.name)
+		if err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("invalid proxy file path %s: %v", f.name, err)
+		}
+		if err := watcher.Add(filepath.Dir(path)); err != nil {
+			watcher.Close()
+			return nil, fmt.Errorf("failed to watch %s: %v", filepath.Dir(path), err)
+		}
+		w.files[path] = f
+	}
+
+	go w.run()
+	return w, nil
+}
+
+func (w *ProxyListWatcher) run() {
+	defer close(w.done)
+
+	// Các file đã thay đổi đang chờ hết thời gian debounce
+	pending := make(map[string]bool)
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			path := filepath.Clean(event.Name)
+			if _, watched := w.files[path]; !watched {
+				continue
+			}
+			if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) {
+				continue
+			}
+			pending[path] = true
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("[ERROR] Proxy file watcher error: %v", err)
+
+		case <-timer.C:
+			for path := range pending {
+				w.reloadFile(path)
+			}
+			pending = make(map[string]bool)
+		}
+	}
+}
+
+// reloadFile tải lại một file proxy, bỏ qua nếu file tạm thời không tồn tại (đang được thay thế)
+func (w *ProxyListWatcher) reloadFile(path string) {
+	file := w.files[path]
+	if _, err := os.Stat(path); err != nil {
+		log.Printf("[WARN] Proxy file %s is not readable, keeping current proxies: %v", file.name, err)
+		return
+	}
+
+	log.Printf("[INFO] Reloading proxy file %s", file.name)
+	load := func() error { return LoadProxiesWithType(file.name, file.proxyType, w.pm) }
+	if file.inventory {
+		load = func() error { return LoadInventory(file.name, w.pm) }
+	}
+	if err := load(); err != nil {
+		log.Printf("[ERROR] Error reloading proxies from %s: %v", file.name, err)
+	}
+}
+
+// Reload tải lại mọi file proxy đang theo dõi, dùng khi nhận SIGHUP
+func (w *ProxyListWatcher) Reload() {
+	for path := range w.files {
+		w.reloadFile(path)
+	}
+}
+
+// Stop dừng theo dõi file
+func (w *ProxyListWatcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+		<-w.done
+		if err := w.watcher.Close(); err != nil {
+			log.Printf("[ERROR] Failed to close proxy file watcher: %v", err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"proxy/proxy"
+)
+
+// runSecrets chạy lệnh secrets: tạo khóa, mã hóa và giải mã file bí mật. Trả về mã thoát.
+func runSecrets(args []string) int {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage:\n")
+		fmt.Fprintf(os.Stderr, "  %s secrets keygen [-o key_file]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s secrets encrypt -key key_file [-o output] [secrets.json]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s secrets decrypt -key key_file [-o output] [secrets.enc]\n", os.Args[0])
+	}
+	if len(args) == 0 {
+		usage()
+		return 2
+	}
+
+	command := args[0]
+	flags := flag.NewFlagSet("secrets "+command, flag.ExitOnError)
+	keyFile := flags.String("key", "", "File chứa khóa AES-256 dạng hex")
+	output := flags.String("o", "", "File ghi kết quả (mặc định stdout), tạo với quyền 0600")
+	flags.Parse(args[1:])
+
+	var result []byte
+	switch command {
+	case "keygen":
+		key, err := proxy.GenerateKey()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to generate key: %v\n", err)
+			return 1
+		}
+		result = []byte(key + "\n")
+	case "encrypt", "decrypt":
+		if *keyFile == "" {
+			usage()
+			return 2
+		}
+		key, err := proxy.ReadKeyFile(*keyFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		input, err := readInput(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if command == "encrypt" {
+			result, err = proxy.EncryptSecrets(input, key)
+		} else {
+			result, err = proxy.DecryptSecrets(input, key)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	default:
+		usage()
+		return 2
+	}
+
+	if *output == "" {
+		os.Stdout.Write(result)
+		return 0
+	}
+	if err := os.WriteFile(*output, result, 0600); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	return 0
+}
+
+// readInput đọc file, hoặc stdin khi không có tên file hay tên file là "-"
+func readInput(filename string) ([]byte, error) {
+	if filename == "" || filename == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(filename)
+}
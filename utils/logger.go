@@ -30,10 +30,10 @@ type Logger struct {
 	*log.Logger
 }
 
-// NewLogger creates a new colorful logger
+// NewLogger creates a new colorful logger, credentials in messages are redacted
 func NewLogger() *Logger {
 	return &Logger{
-		Logger: log.New(os.Stdout, "", 0),
+		Logger: log.New(NewRedactWriter(os.Stdout), "", 0),
 	}
 }
 